@@ -0,0 +1,666 @@
+package cluster
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type aggFunc int
+
+const (
+	aggCount aggFunc = iota
+	aggSum
+	aggAvg
+	aggMin
+	aggMax
+)
+
+var aggNames = [...]string{aggCount: "Count", aggSum: "Sum", aggAvg: "Avg", aggMin: "Min", aggMax: "Max"}
+
+// Aggregation 跨库聚合的列，每个库上计算部分结果后再合并，Avg 由各个库的 SUM 和 COUNT 计算
+type Aggregation struct {
+	fn     aggFunc
+	column string
+	alias  string
+}
+
+func Count(column string) Aggregation {
+	return Aggregation{fn: aggCount, column: column}
+}
+
+func Sum(column string) Aggregation {
+	return Aggregation{fn: aggSum, column: column}
+}
+
+func Avg(column string) Aggregation {
+	return Aggregation{fn: aggAvg, column: column}
+}
+
+func Min(column string) Aggregation {
+	return Aggregation{fn: aggMin, column: column}
+}
+
+func Max(column string) Aggregation {
+	return Aggregation{fn: aggMax, column: column}
+}
+
+// As 结果写入 Aggregate 的 out 中该列对应的字段
+func (a Aggregation) As(alias string) Aggregation {
+	a.alias = alias
+	return a
+}
+
+// selects 每个库上查询的列
+func (a Aggregation) selects(i int) []string {
+	switch a.fn {
+	case aggCount:
+		return []string{fmt.Sprintf("COUNT(%v) AS __agg_%d", a.column, i)}
+	case aggSum:
+		return []string{fmt.Sprintf("SUM(%v) AS __agg_%d", a.column, i)}
+	case aggAvg:
+		return []string{fmt.Sprintf("SUM(%v) AS __agg_%d", a.column, i), fmt.Sprintf("COUNT(%v) AS __agg_%d_n", a.column, i)}
+	case aggMin:
+		return []string{fmt.Sprintf("MIN(%v) AS __agg_%d", a.column, i)}
+	}
+	return []string{fmt.Sprintf("MAX(%v) AS __agg_%d", a.column, i)}
+}
+
+// check 聚合的列拼接在 SQL 中，只能是列名、DISTINCT 列名，Count 还可以是 *
+func (a Aggregation) check() error {
+	column := strings.TrimSpace(a.column)
+	if a.fn == aggCount && column == "*" {
+		return nil
+	}
+
+	if strings.HasPrefix(strings.ToUpper(column), "DISTINCT ") {
+		column = strings.TrimSpace(column[len("DISTINCT "):])
+	}
+
+	if err := checkColumn(column); err != nil {
+		return fmt.Errorf("%v: %w", aggNames[a.fn], err)
+	}
+	return nil
+}
+
+// identifierPattern 列名，可以带表名，可以用反引号
+var identifierPattern = regexp.MustCompile("^(?:(?:`[^`]+`|[A-Za-z_][A-Za-z0-9_$]*)\\.)?(?:`[^`]+`|[A-Za-z_][A-Za-z0-9_$]*)$")
+
+// checkColumn 检查拼接在 SQL 中的列名，防止注入
+func checkColumn(column string) error {
+	if !identifierPattern.MatchString(column) {
+		return fmt.Errorf("invalid column %q", column)
+	}
+	return nil
+}
+
+// aggValue 一个聚合列的合并结果。SUM/AVG 用 big.Rat 精确累加，整数和 DECIMAL 的结果不经过 float64
+type aggValue struct {
+	count int64
+	sum   *big.Rat
+	scale int
+	float bool
+	n     int64
+	value interface{}
+}
+
+// merge typ 为该列的 DatabaseTypeName，driver 不支持时为空
+func (v *aggValue) merge(fn aggFunc, partial, n interface{}, typ string) error {
+	switch fn {
+	case aggCount:
+		c, err := countValue(partial)
+		v.count += c
+		return err
+	case aggAvg:
+		c, err := countValue(n)
+		if err != nil {
+			return err
+		}
+		v.n += c
+		fallthrough
+	case aggSum:
+		s, scale, float, err := ratValue(partial)
+		if err != nil || s == nil {
+			return err
+		}
+
+		if v.sum == nil {
+			v.sum = new(big.Rat)
+		}
+		v.sum.Add(v.sum, s)
+		v.float = v.float || float || floatType(typ)
+		if scale > v.scale {
+			v.scale = scale
+		}
+		return nil
+	}
+
+	if partial == nil {
+		return nil
+	}
+
+	if v.value == nil || (fn == aggMin && compareAgg(partial, v.value, typ) < 0) || (fn == aggMax && compareAgg(partial, v.value, typ) > 0) {
+		v.value = copyValues([]interface{}{partial})[0]
+	}
+	return nil
+}
+
+// result COUNT 为 int64；SUM 整数为 int64，DECIMAL 为保留原精度的字符串，浮点数为 float64；
+// AVG 和 MySQL 一样比 SUM 多 4 位小数；MIN/MAX 为 driver 返回的原值，没有记录时为 nil
+func (v *aggValue) result(fn aggFunc) interface{} {
+	switch fn {
+	case aggCount:
+		return v.count
+	case aggSum:
+		if v.sum == nil {
+			return nil
+		}
+		return ratResult(v.sum, v.scale, v.float)
+	case aggAvg:
+		if v.n == 0 || v.sum == nil {
+			return nil
+		}
+
+		avg := new(big.Rat).Quo(v.sum, new(big.Rat).SetInt64(v.n))
+		if v.float {
+			f, _ := avg.Float64()
+			return f
+		}
+		return avg.FloatString(v.scale + 4)
+	}
+	return v.value
+}
+
+func ratResult(r *big.Rat, scale int, float bool) interface{} {
+	if float {
+		f, _ := r.Float64()
+		return f
+	}
+
+	if scale == 0 && r.IsInt() && r.Num().IsInt64() {
+		return r.Num().Int64()
+	}
+	return r.FloatString(scale)
+}
+
+// compareAgg 数值列按数值比较，driver 用文本协议时 DECIMAL、INT 都以 []byte 返回；
+// 其他列和 Order 的归并一样用 compareValues，DATETIME 和字符串都可以比较
+func compareAgg(a, b interface{}, typ string) int {
+	if numericType(typ) {
+		x, _, _, errx := ratValue(a)
+		y, _, _, erry := ratValue(b)
+		if errx == nil && erry == nil && x != nil && y != nil {
+			return x.Cmp(y)
+		}
+	}
+	return compareValues(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func numericType(typ string) bool {
+	fields := strings.Fields(strings.ToUpper(typ))
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[len(fields)-1] {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL":
+		return true
+	}
+	return false
+}
+
+func floatType(typ string) bool {
+	fields := strings.Fields(strings.ToUpper(typ))
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[len(fields)-1] {
+	case "FLOAT", "DOUBLE", "REAL":
+		return true
+	}
+	return false
+}
+
+type aggGroup struct {
+	keys   []interface{}
+	values []*aggValue
+}
+
+// Group specify the group method on the find，各个库的结果按分组的值合并
+func (q *Query) Group(columns ...string) *Query {
+	nq := q.clone()
+	nq.groups = append(nq.groups, columns...)
+	return nq
+}
+
+// aggregate 并发在所有表上聚合并按分组合并
+func (q *Query) aggregate(model interface{}, aggs []Aggregation) ([]*aggGroup, error) {
+	if model == nil {
+		return nil, fmt.Errorf("aggregate needs Model")
+	}
+
+	for _, column := range q.groups {
+		if err := checkColumn(column); err != nil {
+			return nil, fmt.Errorf("group: %w", err)
+		}
+	}
+
+	for _, a := range aggs {
+		if err := a.check(); err != nil {
+			return nil, err
+		}
+	}
+
+	targets, err := q.targets(model)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkDistinct(targets, aggs); err != nil {
+		return nil, err
+	}
+
+	selects := append([]string(nil), q.groups...)
+	for i, a := range aggs {
+		selects = append(selects, a.selects(i)...)
+	}
+
+	var (
+		mtx    sync.Mutex
+		order  []string
+		groups = make(map[string]*aggGroup)
+	)
+	ferr := q.fanout(targets, func(_ int, t *queryTarget, table string) error {
+		db := q.conditions(t.node, table).Select(strings.Join(selects, ", "))
+		if len(q.groups) > 0 {
+			db = db.Group(strings.Join(q.groups, ", "))
+		}
+
+		rows, err := db.Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		types := make([]string, len(selects))
+		if columns, err := rows.ColumnTypes(); err == nil && len(columns) == len(types) {
+			for i, c := range columns {
+				types[i] = c.DatabaseTypeName()
+			}
+		}
+
+		for rows.Next() {
+			values := make([]interface{}, len(selects))
+			dest := make([]interface{}, len(selects))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+
+			if err := mergeGroup(&mtx, groups, &order, values, types, len(q.groups), aggs); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+
+	result := make([]*aggGroup, 0, len(order))
+	for _, k := range order {
+		result = append(result, groups[k])
+	}
+	return result, ferr
+}
+
+// checkDistinct 各个表的 COUNT(DISTINCT x) 等结果中可能有相同的值，不能相加，只有一张表时可以使用
+func checkDistinct(targets []*queryTarget, aggs []Aggregation) error {
+	tables := 0
+	for _, t := range targets {
+		tables += len(t.tables)
+	}
+	if tables <= 1 {
+		return nil
+	}
+
+	for _, a := range aggs {
+		distinct := strings.HasPrefix(strings.ToUpper(strings.TrimSpace(a.column)), "DISTINCT ")
+		if distinct && (a.fn == aggCount || a.fn == aggSum || a.fn == aggAvg) {
+			return fmt.Errorf("%v(%v) can not be merged across %v tables", aggNames[a.fn], a.column, tables)
+		}
+	}
+	return nil
+}
+
+// groupKey 分组的值，不同库的驱动对同一个数值可能返回 int64 或者 []byte，数值统一后再比较。
+// typ 是列的类型，字符串列的 "1" 和 "1.0" 是不同的分组
+func groupKey(v interface{}, typ string) string {
+	if v == nil {
+		return "nil"
+	}
+
+	sv := sortValue(reflect.ValueOf(v))
+	var r *big.Rat
+	switch n := sv.(type) {
+	case int64:
+		r = new(big.Rat).SetInt64(n)
+	case uint64:
+		r = new(big.Rat).SetUint64(n)
+	case float64:
+		r, _ = new(big.Rat).SetString(strconv.FormatFloat(n, 'g', -1, 64))
+	case string:
+		if numericType(typ) {
+			r, _ = new(big.Rat).SetString(n)
+		}
+	}
+
+	if r != nil {
+		return "n:" + r.RatString()
+	}
+	return fmt.Sprintf("%T:%v", sv, sv)
+}
+
+func mergeGroup(mtx *sync.Mutex, groups map[string]*aggGroup, order *[]string, values []interface{}, types []string, groupNum int, aggs []Aggregation) error {
+	keys := values[:groupNum]
+	k := make([]string, 0, groupNum)
+	for i, v := range keys {
+		k = append(k, groupKey(v, types[i]))
+	}
+	key := strings.Join(k, "\x00")
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	g, ok := groups[key]
+	if !ok {
+		g = &aggGroup{keys: copyValues(keys)}
+		for range aggs {
+			g.values = append(g.values, &aggValue{})
+		}
+		groups[key] = g
+		*order = append(*order, key)
+	}
+
+	i := groupNum
+	for j, a := range aggs {
+		var n interface{}
+		if a.fn == aggAvg {
+			n = values[i+1]
+		}
+
+		if err := g.values[j].merge(a.fn, values[i], n, types[i]); err != nil {
+			return err
+		}
+
+		i++
+		if a.fn == aggAvg {
+			i++
+		}
+	}
+	return nil
+}
+
+// copyValues driver 返回的 []byte 在下一次 Scan 时会被复用
+func copyValues(values []interface{}) []interface{} {
+	cp := make([]interface{}, len(values))
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+		cp[i] = v
+	}
+	return cp
+}
+
+// Aggregate 按 Group 的列跨库聚合，out 为结构体切片的指针，分组列和聚合的 As 写入同名的字段
+//
+//	cluster.Model(&Order{}).Group("city").Aggregate(&rows, cluster.Count("*").As("cnt"), cluster.Avg("amount").As("avg_amount"))
+func (q *Query) Aggregate(out interface{}, aggs ...Aggregation) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("aggregate needs pointer to slice, got %T", out)
+	}
+
+	sliceType := rv.Elem().Type()
+	elemType := sliceType.Elem()
+	ptr := elemType.Kind() == reflect.Ptr
+	if ptr {
+		elemType = elemType.Elem()
+	}
+
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("aggregate needs slice of struct, got %v", sliceType)
+	}
+
+	model := q.model
+	if model == nil {
+		model = reflect.New(elemType).Interface()
+	}
+
+	groups, err := q.aggregate(model, aggs)
+	result := reflect.MakeSlice(sliceType, 0, len(groups))
+	for _, g := range groups {
+		row := reflect.New(elemType)
+		for i, column := range q.groups {
+			if err := setColumn(row.Elem(), column, g.keys[i]); err != nil {
+				return err
+			}
+		}
+
+		for i, a := range aggs {
+			if a.alias == "" {
+				continue
+			}
+
+			if err := setColumn(row.Elem(), a.alias, g.values[i].result(a.fn)); err != nil {
+				return err
+			}
+		}
+
+		if ptr {
+			result = reflect.Append(result, row)
+		} else {
+			result = reflect.Append(result, row.Elem())
+		}
+	}
+	rv.Elem().Set(result)
+	return err
+}
+
+// Count get how many records for a model across all shards，有 Group 时用 Aggregate 获取每个分组的结果
+func (q *Query) Count(count *int64) error {
+	return q.scalar(Count("*"), count)
+}
+
+// Sum 跨库求和，sum 为 *int64、*float64、*string 等可以写入结果的指针，DECIMAL 列用 *string 可以保留全部精度
+func (q *Query) Sum(column string, sum interface{}) error {
+	return q.scalar(Sum(column), sum)
+}
+
+// Avg 跨库平均值，没有记录时写入零值，avg 为 *sql.NullFloat64 时 Valid 为 false
+func (q *Query) Avg(column string, avg interface{}) error {
+	return q.scalar(Avg(column), avg)
+}
+
+// Min 跨库最小值，支持数值、字符串和时间列，min 为该列对应类型的指针
+func (q *Query) Min(column string, min interface{}) error {
+	return q.scalar(Min(column), min)
+}
+
+// Max 跨库最大值，支持数值、字符串和时间列，max 为该列对应类型的指针
+func (q *Query) Max(column string, max interface{}) error {
+	return q.scalar(Max(column), max)
+}
+
+func (q *Query) scalar(a Aggregation, out interface{}) error {
+	if len(q.groups) > 0 {
+		return fmt.Errorf("%v with Group has a result per group, use Aggregate", aggNames[a.fn])
+	}
+
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%v needs a non-nil pointer, got %T", aggNames[a.fn], out)
+	}
+
+	groups, err := q.aggregate(q.model, []Aggregation{a})
+	var value interface{}
+	if len(groups) > 0 {
+		value = groups[0].values[0].result(a.fn)
+	} else if a.fn == aggCount {
+		value = int64(0)
+	}
+
+	if serr := setValue(rv.Elem(), value); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
+
+// countValue COUNT 的结果为整数，文本协议时为 []byte
+func countValue(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return n, nil
+	case []byte:
+		return strconv.ParseInt(string(n), 10, 64)
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, fmt.Errorf("aggregate count %v(%T) is not integer", v, v)
+}
+
+// ratValue 把部分结果转成 big.Rat，scale 为 DECIMAL 的小数位数，float 表示 driver 返回的是浮点数
+func ratValue(v interface{}) (r *big.Rat, scale int, float bool, err error) {
+	switch n := v.(type) {
+	case nil:
+		return nil, 0, false, nil
+	case []byte:
+		return decimalValue(string(n))
+	case string:
+		return decimalValue(n)
+	case int64:
+		return new(big.Rat).SetInt64(n), 0, false, nil
+	case uint64:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(n)), 0, false, nil
+	case float32:
+		v = float64(n)
+	}
+
+	if f, ok := v.(float64); ok {
+		if r := new(big.Rat).SetFloat64(f); r != nil {
+			return r, 0, true, nil
+		}
+	}
+	return nil, 0, false, fmt.Errorf("aggregate value %v(%T) is not number", v, v)
+}
+
+func decimalValue(s string) (*big.Rat, int, bool, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, 0, false, fmt.Errorf("aggregate value %q is not number", s)
+	}
+
+	// 科学计数法只会来自浮点数列
+	if strings.ContainsAny(s, "eE") {
+		return r, 0, true, nil
+	}
+
+	scale := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		scale = len(s) - i - 1
+	}
+	return r, scale, false, nil
+}
+
+// setColumn 把 value 写入 row 中 column 对应的字段，找不到字段时忽略
+func setColumn(row reflect.Value, column string, value interface{}) error {
+	index := columnField(row.Type(), column)
+	if index == nil {
+		return nil
+	}
+
+	return setValue(row.FieldByIndex(index), value)
+}
+
+// setValue 把聚合结果写入 field，整数和 DECIMAL 的字符串先按整数解析，避免经过 float64 丢失精度
+func setValue(field reflect.Value, value interface{}) error {
+	if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	if field.Kind() == reflect.Ptr {
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
+
+	if t, ok := value.(time.Time); ok && field.Type() == reflect.TypeOf(t) {
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	s := fmt.Sprint(value)
+	if b, ok := value.([]byte); ok {
+		s = string(b)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			field.SetInt(n)
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(f))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			field.SetUint(n)
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(uint64(f))
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		if reflect.TypeOf(value).AssignableTo(field.Type()) {
+			field.Set(reflect.ValueOf(value))
+			return nil
+		}
+		return fmt.Errorf("can not set %v(%T) to %v", value, value, field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package cluster
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggValueSum(t *testing.T) {
+	cases := []struct {
+		typ      string
+		partials []interface{}
+		want     interface{}
+	}{
+		{"DECIMAL", []interface{}{[]byte("0.1"), []byte("0.2")}, "0.3"},
+		{"DECIMAL", []interface{}{[]byte("1.50"), []byte("2"), nil}, "3.50"},
+		{"DECIMAL", []interface{}{[]byte("9223372036854775807"), []byte("1")}, "9223372036854775808"},
+		{"BIGINT", []interface{}{int64(math.MaxInt64 - 1), int64(1)}, int64(math.MaxInt64)},
+		{"", []interface{}{int64(9007199254740993), int64(1)}, int64(9007199254740994)},
+		{"DOUBLE", []interface{}{[]byte("1.5"), []byte("2.25")}, 3.75},
+		{"", []interface{}{0.5, int64(1)}, 1.5},
+		{"DECIMAL", []interface{}{nil, nil}, nil},
+	}
+
+	for _, c := range cases {
+		v := &aggValue{}
+		for _, p := range c.partials {
+			if err := v.merge(aggSum, p, nil, c.typ); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := v.result(aggSum); got != c.want {
+			t.Errorf("%v %v got %#v, want %#v", c.typ, c.partials, got, c.want)
+		}
+	}
+}
+
+func TestAggValueAvgCount(t *testing.T) {
+	v := &aggValue{}
+	for _, p := range [][2]interface{}{{[]byte("3"), []byte("2")}, {int64(4), int64(1)}, {nil, int64(0)}} {
+		if err := v.merge(aggAvg, p[0], p[1], "DECIMAL"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := v.result(aggAvg); got != "2.3333" {
+		t.Errorf("avg got %#v", got)
+	}
+
+	c := &aggValue{}
+	for _, p := range []interface{}{int64(3), []byte("4"), nil} {
+		if err := c.merge(aggCount, p, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := c.result(aggCount); got != int64(7) {
+		t.Errorf("count got %#v", got)
+	}
+
+	if err := c.merge(aggCount, 1.5, nil, ""); err == nil {
+		t.Error("float count accepted")
+	}
+
+	if got := (&aggValue{}).result(aggAvg); got != nil {
+		t.Errorf("empty avg got %#v", got)
+	}
+}
+
+func TestAggValueMinMax(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	cases := []struct {
+		typ      string
+		partials []interface{}
+		min, max interface{}
+	}{
+		{"DATETIME", []interface{}{day(3), nil, day(1), day(2)}, day(1), day(3)},
+		{"VARCHAR", []interface{}{[]byte("b"), []byte("a"), []byte("c")}, "a", "c"},
+		{"DECIMAL", []interface{}{[]byte("9.5"), []byte("10.25"), []byte("-1")}, "-1", "10.25"},
+		{"BIGINT", []interface{}{int64(5), int64(-2)}, int64(-2), int64(5)},
+	}
+
+	for _, c := range cases {
+		min, max := &aggValue{}, &aggValue{}
+		for _, p := range c.partials {
+			if err := min.merge(aggMin, p, nil, c.typ); err != nil {
+				t.Fatal(err)
+			}
+			if err := max.merge(aggMax, p, nil, c.typ); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := plain(min.result(aggMin)); got != c.min {
+			t.Errorf("%v min got %#v, want %#v", c.typ, got, c.min)
+		}
+		if got := plain(max.result(aggMax)); got != c.max {
+			t.Errorf("%v max got %#v, want %#v", c.typ, got, c.max)
+		}
+	}
+}
+
+func plain(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+type aggOrder struct {
+	ID      int64
+	UserID  int64
+	City    string
+	Amount  string
+	Created time.Time
+}
+
+func (aggOrder) TableName() string {
+	return "order"
+}
+
+func TestQueryScalar(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	partials := [][]driver.Value{
+		{[]byte("0.10"), int64(2), time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{[]byte("0.20"), int64(3), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for i, s := range servers {
+		p := partials[i]
+		s.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "SUM("):
+				return []string{"__agg_0"}, [][]driver.Value{{p[0]}}
+			case strings.Contains(query, "COUNT("):
+				return []string{"__agg_0"}, [][]driver.Value{{p[1]}}
+			case strings.Contains(query, "MIN("):
+				return []string{"__agg_0"}, [][]driver.Value{{p[2]}}
+			}
+			return nil, nil
+		})
+	}
+
+	q := c.Model(&aggOrder{})
+
+	var sum string
+	if err := q.Sum("amount", &sum); err != nil || sum != "0.30" {
+		t.Fatalf("sum %v %v", sum, err)
+	}
+
+	var f sql.NullFloat64
+	if err := q.Sum("amount", &f); err != nil || !f.Valid || f.Float64 != 0.3 {
+		t.Fatalf("sum %v %v", f, err)
+	}
+
+	var count int64
+	if err := q.Count(&count); err != nil || count != 5 {
+		t.Fatalf("count %v %v", count, err)
+	}
+
+	var min time.Time
+	if err := q.Min("created", &min); err != nil || !min.Equal(partials[1][2].(time.Time)) {
+		t.Fatalf("min %v %v", min, err)
+	}
+
+	if err := q.Group("city").Count(&count); err == nil {
+		t.Fatal("count with group accepted")
+	}
+
+	if err := q.Group("city").Sum("amount", &sum); err == nil {
+		t.Fatal("sum with group accepted")
+	}
+
+	if err := q.Sum("amount", sum); err == nil {
+		t.Fatal("sum into non-pointer accepted")
+	}
+}
+
+func TestQueryAggregateGroups(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	for i, s := range servers {
+		i := i
+		s.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			if !strings.Contains(query, "GROUP BY") {
+				return nil, nil
+			}
+			columns := []string{"city", "__agg_0", "__agg_1", "__agg_1_n"}
+			if i == 0 {
+				return columns, [][]driver.Value{{[]byte("bj"), int64(2), []byte("3.00"), int64(2)}, {[]byte("sh"), int64(1), []byte("1.00"), int64(1)}}
+			}
+			return columns, [][]driver.Value{{[]byte("bj"), int64(1), []byte("4.00"), int64(1)}}
+		})
+	}
+
+	type row struct {
+		City      string
+		Cnt       int64
+		AvgAmount string
+	}
+
+	var rows []row
+	err := c.Model(&aggOrder{}).Group("city").Aggregate(&rows, Count("*").As("cnt"), Avg("amount").As("avg_amount"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("rows %v", rows)
+	}
+
+	for _, r := range rows {
+		want := map[string]row{"bj": {"bj", 3, "2.333333"}, "sh": {"sh", 1, "1.000000"}}[r.City]
+		if r != want {
+			t.Errorf("got %v, want %v", r, want)
+		}
+	}
+}
+
+func TestGroupKey(t *testing.T) {
+	cases := []struct {
+		a, b       interface{}
+		typA, typB string
+		same       bool
+	}{
+		{int64(5), []byte("5"), "", "BIGINT", true},
+		{uint64(5), int64(5), "", "", true},
+		{1.5, []byte("1.50"), "DOUBLE", "DECIMAL", true},
+		{[]byte("bj"), "bj", "VARCHAR", "", true},
+		{[]byte("1"), []byte("1.0"), "VARCHAR", "VARCHAR", false},
+		{int64(5), []byte("5"), "", "VARCHAR", false},
+		{nil, int64(0), "", "", false},
+	}
+
+	for _, c := range cases {
+		if same := groupKey(c.a, c.typA) == groupKey(c.b, c.typB); same != c.same {
+			t.Errorf("%#v(%v) and %#v(%v) same %v, want %v", c.a, c.typA, c.b, c.typB, same, c.same)
+		}
+	}
+}
+
+func TestQueryDistinctAcrossTables(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	var n int64
+	for _, a := range []Aggregation{Count("DISTINCT user_id"), Sum(" distinct amount"), Avg("DISTINCT amount")} {
+		if err := c.Model(&aggOrder{}).scalar(a, &n); err == nil {
+			t.Errorf("%v(%v) across tables succeeded", aggNames[a.fn], a.column)
+		}
+	}
+	for _, s := range servers {
+		if stmts := s.statements("SELECT"); len(stmts) != 0 {
+			t.Fatalf("statements = %q, want none", stmts)
+		}
+	}
+
+	// MIN/MAX 不受重复值影响
+	var max int64
+	if err := c.Model(&aggOrder{}).Max("DISTINCT amount", &max); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type aggDeleted struct {
+	ID        int64
+	UserID    int64 `gorm-cluster:"shard_key"`
+	DeletedAt *time.Time
+}
+
+func (aggDeleted) TableName() string {
+	return "agg_deleted"
+}
+
+func TestQueryCountSoftDelete(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	var count int64
+	if err := c.Model(&aggDeleted{}).Count(&count); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range servers {
+		stmts := s.statements("COUNT(")
+		if len(stmts) != 1 || !strings.Contains(stmts[0], "deleted_at") || !strings.Contains(stmts[0], "IS NULL") {
+			t.Errorf("db %d got %q", i, stmts)
+		}
+	}
+}
+
+func TestQueryAggregateColumns(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	q := c.Model(&aggOrder{})
+
+	var sum int64
+	for _, column := range []string{"amount) FROM users; --", "amount + 1", "", "DISTINCT a, b"} {
+		if err := q.Sum(column, &sum); err == nil {
+			t.Errorf("Sum(%q) accepted", column)
+		}
+	}
+
+	var rows []struct{ City string }
+	if err := q.Group("city; DROP TABLE users").Aggregate(&rows, Count("*")); err == nil {
+		t.Error("Group with statement accepted")
+	}
+	for i, s := range servers {
+		if stmts := s.statements("SELECT"); len(stmts) != 0 {
+			t.Errorf("db %d got %q", i, stmts)
+		}
+	}
+
+	for _, column := range []string{"amount", "`order`.`amount`", "o.amount", " DISTINCT amount"} {
+		if err := q.Max(column, &sum); err != nil {
+			t.Errorf("Max(%q): %v", column, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/go-gorm/gorm"
+)
+
+// Association 关联模式，写入前检查 master 是否已经切换，记录耗时和错误
+type Association struct {
+	node  *ClusterNode
+	assoc *gorm.Association
+	// wrote 写入成功后调用，Sharding 用来在会话中记录
+	wrote func()
+
+	Error error
+}
+
+// Association start `Association Mode` to handler relations things easir in that mode, refer: https://jinzhu.github.io/gorm/associations.html#association-mode
+func (n *ClusterNode) Association(column string) *Association {
+	if n.db.Error != nil {
+		return &Association{node: n, Error: n.db.Error}
+	}
+
+	assoc := n.db.Association(column)
+	return &Association{node: n, assoc: assoc, Error: assoc.Error}
+}
+
+// Association 关联模式在 master 上执行，写入成功后在会话中记录
+func (n *Sharding) Association(column string) *Association {
+	a := n.primary().Association(column)
+	a.wrote = func() {
+		if n.session != nil {
+			n.session.wrote(n)
+		}
+	}
+	return a
+}
+
+// Find find out all related associations
+func (a *Association) Find(value interface{}) *Association {
+	return a.run(false, func(assoc *gorm.Association) {
+		assoc.Find(value)
+	})
+}
+
+// Append append new associations for many2many, has_many, replace current association for has_one, belongs_to
+func (a *Association) Append(values ...interface{}) *Association {
+	return a.run(true, func(assoc *gorm.Association) {
+		assoc.Append(values...)
+	})
+}
+
+// Replace replace current associations with new one
+func (a *Association) Replace(values ...interface{}) *Association {
+	return a.run(true, func(assoc *gorm.Association) {
+		assoc.Replace(values...)
+	})
+}
+
+// Delete remove relationship between source & passed arguments, but won't delete those arguments
+func (a *Association) Delete(values ...interface{}) *Association {
+	return a.run(true, func(assoc *gorm.Association) {
+		assoc.Delete(values...)
+	})
+}
+
+// Clear remove relationship between source & current associations, won't delete those associations
+func (a *Association) Clear() *Association {
+	return a.run(true, func(assoc *gorm.Association) {
+		assoc.Clear()
+	})
+}
+
+// Count return the count of current associations
+func (a *Association) Count() (count int) {
+	a.run(false, func(assoc *gorm.Association) {
+		count = assoc.Count()
+	})
+	return count
+}
+
+// run 执行 fn，已经有错误时不执行
+func (a *Association) run(write bool, fn func(assoc *gorm.Association)) *Association {
+	if a.Error != nil {
+		return a
+	}
+
+	if write {
+		if err := a.node.canWrite(); err != nil {
+			a.Error = err
+			return a
+		}
+	}
+
+	start := time.Now()
+	fn(a.assoc)
+	a.Error = a.node.done(start, a.assoc.Error)
+
+	if write && a.Error == nil && a.wrote != nil {
+		a.wrote()
+	}
+	return a
+}
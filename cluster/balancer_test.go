@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+)
+
+func weightedNode(t *testing.T, weight int) *ClusterNode {
+	t.Helper()
+
+	node, err := NewClusterNode(WithDB(&DB{Weight: weight}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	a, b, c := weightedNode(t, 5), weightedNode(t, 1), weightedNode(t, 1)
+	nodes := []*ClusterNode{a, b, c}
+	names := map[*ClusterNode]string{a: "a", b: "b", c: "c"}
+
+	wrr := WeightedRoundRobin()
+	var got string
+	for i := 0; i < 14; i++ {
+		got += names[wrr.Next(nodes)]
+	}
+
+	if got != "aabacaaaabacaa" {
+		t.Fatalf("sequence %v", got)
+	}
+
+	// 不再参与选择的节点被清除，c 重新加入时从 0 开始，和 d 相同时选择排在前面的 d
+	wrr = WeightedRoundRobin()
+	for i := 0; i < 3; i++ {
+		wrr.Next(nodes)
+	}
+	wrr.Next([]*ClusterNode{a})
+
+	d := weightedNode(t, 1)
+	if got := wrr.Next([]*ClusterNode{d, c}); got != d {
+		t.Fatalf("stale weight of removed node kept, got %v", names[got])
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b := weightedNode(t, 1), weightedNode(t, 1)
+	rr := RoundRobin()
+	for i, want := range []*ClusterNode{a, b, a, b} {
+		if got := rr.Next([]*ClusterNode{a, b}); got != want {
+			t.Fatalf("round %v got wrong node", i)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	a, b := weightedNode(t, 1), weightedNode(t, 1)
+	r := Random()
+	seen := make(map[*ClusterNode]int)
+	for i := 0; i < 100; i++ {
+		seen[r.Next([]*ClusterNode{a, b})]++
+	}
+
+	if len(seen) != 2 || seen[a]+seen[b] != 100 {
+		t.Fatalf("picked %v", seen)
+	}
+}
+
+func TestLeastInFlight(t *testing.T) {
+	busy, _ := newFakeNode(t, t.Name()+"/busy")
+	idle, _ := newFakeNode(t, t.Name()+"/idle")
+
+	conn, err := busy.db.DB().Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	lif := LeastInFlight()
+	for i := 0; i < 10; i++ {
+		if lif.Next([]*ClusterNode{busy, idle}) != idle {
+			t.Fatal("busy node picked")
+		}
+	}
+
+	// 没有 Open 的节点不参与比较
+	closed := weightedNode(t, 1)
+	for i := 0; i < 10; i++ {
+		if lif.Next([]*ClusterNode{closed, busy}) != busy {
+			t.Fatal("node not open picked")
+		}
+	}
+}
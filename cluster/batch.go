@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gorm/gorm"
+)
+
+const (
+	defaultBatchSize = 1000
+	// maxPlaceholders MySQL 一条语句最多 65535 个占位符
+	maxPlaceholders = 65535
+)
+
+// batchRow 一条要插入的记录和它在输入中的位置
+type batchRow struct {
+	index int
+	value reflect.Value
+}
+
+type batchTable struct {
+	index    int
+	sharding *Sharding
+	node     *ClusterNode
+	table    string
+	// columns 要插入的列，自增主键为空和不为空的记录插入的列不同，分开插入
+	columns string
+	rows    []*batchRow
+}
+
+// CreateInBatches 按 `gorm-cluster:"shard_key"` 把 values 中的记录路由到各个库的各张表，
+// 每张表每 batchSize 条拼成一条多行 INSERT，各个库并发执行。batchSize <= 0 时为 1000，
+// 列多时自动减少每条 INSERT 的行数，保证占位符不超过 65535 个。
+// rowErrs 与 values 一一对应，nil 表示该条插入成功；有失败的记录时 err 不为 nil。
+// 多行 INSERT 不走 gorm 的 callback，也不会回填自增主键
+func (c *Cluster) CreateInBatches(values interface{}, batchSize int) (rowErrs []error, err error) {
+	return c.createInBatches(nil, values, batchSize)
+}
+
+// CreateInBatches 同 Cluster.CreateInBatches，插入成功的库记录在会话中
+func (s *Session) CreateInBatches(values interface{}, batchSize int) (rowErrs []error, err error) {
+	return s.cluster.createInBatches(s, values, batchSize)
+}
+
+func (c *Cluster) createInBatches(session *Session, values interface{}, batchSize int) (rowErrs []error, err error) {
+	rv := reflect.Indirect(reflect.ValueOf(values))
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("create in batches needs slice, got %T", values)
+	}
+
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	rowErrs = make([]error, rv.Len())
+	shards := make(map[int]map[string]*batchTable)
+	for i := 0; i < rv.Len(); i++ {
+		row := &batchRow{index: i, value: rv.Index(i)}
+		bt, err := c.batchRoute(row.value)
+		if err != nil {
+			rowErrs[i] = err
+			continue
+		}
+
+		if shards[bt.index] == nil {
+			shards[bt.index] = make(map[string]*batchTable)
+		}
+
+		key := bt.table + "(" + bt.columns + ")"
+		if t, ok := shards[bt.index][key]; ok {
+			bt = t
+		} else {
+			shards[bt.index][key] = bt
+		}
+		bt.rows = append(bt.rows, row)
+	}
+
+	var wg sync.WaitGroup
+	for _, tables := range shards {
+		wg.Add(1)
+		go func(tables map[string]*batchTable) {
+			defer wg.Done()
+			for _, bt := range tables {
+				size := bt.batchSize(batchSize)
+				for start := 0; start < len(bt.rows); start += size {
+					end := start + size
+					if end > len(bt.rows) {
+						end = len(bt.rows)
+					}
+
+					if err := bt.insert(bt.rows[start:end]); err != nil {
+						err = &ShardError{DBIndex: bt.index, Table: bt.table, Err: err}
+						for _, row := range bt.rows[start:end] {
+							rowErrs[row.index] = err
+						}
+					} else if session != nil {
+						session.wrote(bt.sharding)
+					}
+				}
+			}
+		}(tables)
+	}
+	wg.Wait()
+
+	var failed int
+	for _, e := range rowErrs {
+		if e != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		err = fmt.Errorf("create in batches: %d of %d rows failed", failed, len(rowErrs))
+	}
+	return rowErrs, err
+}
+
+// batchRoute 计算一条记录所在的库和表
+func (c *Cluster) batchRoute(value reflect.Value) (*batchTable, error) {
+	ptr := value
+	if ptr.Kind() != reflect.Ptr {
+		if !ptr.CanAddr() {
+			return nil, fmt.Errorf("create in batches needs addressable value, got %v", value.Type())
+		}
+		ptr = ptr.Addr()
+	}
+
+	// ShardKey 已经把 int 等内置整数类型统一为 int64，和 Cluster.DB、Cluster.Where 路由到同一个库
+	key, err := ShardKey(ptr.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := c.dbIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sh := c.sharding(int(idx))
+	node := sh.primary()
+	if err := node.Error(); err != nil {
+		return nil, err
+	}
+
+	sv := ShardingValue{value: ptr.Interface(), shradingValues: []interface{}{key},
+		tableNum: node.opts.tableNum, dbIndex: node.opts.dbIndex, tableSelector: node.opts.tableSelector}
+	table, err := sv.RouteTableName()
+	if err != nil {
+		return nil, err
+	}
+	var columns []string
+	for _, field := range insertFields(node.db.NewScope(ptr.Interface())) {
+		columns = append(columns, field.DBName)
+	}
+	return &batchTable{index: int(idx), sharding: sh, node: node, table: table, columns: strings.Join(columns, ",")}, nil
+}
+
+// batchSize 每条 INSERT 的行数，列数乘行数不超过 maxPlaceholders
+func (bt *batchTable) batchSize(size int) int {
+	value := bt.rows[0].value
+	if value.Kind() != reflect.Ptr {
+		value = value.Addr()
+	}
+
+	if n := len(insertFields(bt.node.db.NewScope(value.Interface()))); n > 0 && size > maxPlaceholders/n {
+		return maxPlaceholders / n
+	}
+	return size
+}
+
+// insertFields 要插入的列，自增主键为空时不插入
+func insertFields(scope *gorm.Scope) []*gorm.Field {
+	var fields []*gorm.Field
+	for _, field := range scope.Fields() {
+		if !field.IsNormal || field.IsIgnored || (field.IsPrimaryKey && field.IsBlank) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// insert 拼成一条多行 INSERT，列以第一条记录为准，自增主键为空时不插入
+func (bt *batchTable) insert(rows []*batchRow) error {
+	if err := bt.node.canWrite(); err != nil {
+		return err
+	}
+
+	var (
+		columns []string
+		holders []string
+		vars    []interface{}
+	)
+
+	now := gorm.NowFunc()
+	for i, row := range rows {
+		value := row.value
+		if value.Kind() != reflect.Ptr {
+			value = value.Addr()
+		}
+
+		scope := bt.node.db.NewScope(value.Interface())
+		var placeholders []string
+		for _, field := range insertFields(scope) {
+			if (field.Name == "CreatedAt" || field.Name == "UpdatedAt") && field.IsBlank {
+				if err := field.Set(now); err != nil {
+					return err
+				}
+			}
+
+			if i == 0 {
+				columns = append(columns, scope.Quote(field.DBName))
+			}
+			placeholders = append(placeholders, "?")
+			vars = append(vars, field.Field.Interface())
+		}
+
+		if len(placeholders) != len(columns) {
+			return fmt.Errorf("row %d has %d columns, first row has %d", row.index, len(placeholders), len(columns))
+		}
+		holders = append(holders, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %v (%v) VALUES %v",
+		bt.node.db.NewScope(nil).Quote(bt.table), strings.Join(columns, ", "), strings.Join(holders, ", "))
+	start := time.Now()
+	return bt.node.done(start, bt.node.db.Exec(sql, vars...).Error)
+}
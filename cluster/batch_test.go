@@ -0,0 +1,156 @@
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type batchUser struct {
+	ID     int64
+	UserID int64 `gorm-cluster:"shard_key"`
+	Name   string
+}
+
+func (batchUser) TableName() string {
+	return "batch_user"
+}
+
+func TestCreateInBatches(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	servers[1].setHook(func(query string) error {
+		if strings.Contains(query, "INSERT") {
+			return errors.New("db 1 down")
+		}
+		return nil
+	})
+
+	users := []batchUser{{UserID: 2}, {UserID: 3}, {UserID: 0}, {UserID: 4}}
+	rowErrs, err := c.CreateInBatches(users, 0)
+	if err == nil {
+		t.Fatal("failed rows not reported")
+	}
+
+	if rowErrs[0] != nil || rowErrs[3] != nil {
+		t.Fatalf("db 0 rows failed: %v", rowErrs)
+	}
+
+	var se *ShardError
+	if !errors.As(rowErrs[1], &se) || se.DBIndex != 1 {
+		t.Fatalf("db 1 row got %v", rowErrs[1])
+	}
+
+	if !errors.Is(rowErrs[2], ErrZeroShardKey) {
+		t.Fatalf("zero key row got %v", rowErrs[2])
+	}
+
+	inserts := servers[0].statements("INSERT")
+	if len(inserts) != 1 || strings.Count(inserts[0], "?") != 4 {
+		t.Fatalf("db 0 inserts %v", inserts)
+	}
+}
+
+func TestCreateInBatchesIntKey(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	users := []tagInt{{UserID: 2}, {UserID: 3}}
+	if rowErrs, err := c.CreateInBatches(users, 0); err != nil {
+		t.Fatalf("CreateInBatches error = %v, rows %v", err, rowErrs)
+	}
+	for i, s := range servers {
+		if len(s.statements("INSERT")) != 1 {
+			t.Errorf("db %d got %q", i, s.statements("INSERT"))
+		}
+	}
+}
+
+func TestCreateInBatchesPlaceholders(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 1)
+
+	users := make([]batchUser, 50000)
+	for i := range users {
+		users[i].UserID = int64(i + 1)
+	}
+
+	if _, err := c.CreateInBatches(users, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(servers[0].statements("INSERT")); n != 50 {
+		t.Fatalf("default batch size made %v inserts", n)
+	}
+	servers[0].reset()
+
+	// 两列时每条最多 32767 行
+	if _, err := c.CreateInBatches(users, 40000); err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := servers[0].statements("INSERT")
+	if len(inserts) != 2 {
+		t.Fatalf("%v inserts", len(inserts))
+	}
+	for _, stmt := range inserts {
+		if n := strings.Count(stmt, "?"); n > maxPlaceholders {
+			t.Fatalf("insert has %v placeholders", n)
+		}
+	}
+}
+
+func TestCreateInBatchesMixedPrimaryKey(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 1)
+
+	// 自增主键为空的记录不插入 id 列，分开插入
+	users := []batchUser{{UserID: 1}, {ID: 5, UserID: 2}, {UserID: 3}}
+	if _, err := c.CreateInBatches(users, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := servers[0].statements("INSERT")
+	if len(inserts) != 2 {
+		t.Fatalf("inserts %q, want one per column set", inserts)
+	}
+	for _, stmt := range inserts {
+		if strings.Contains(stmt, "`id`") != (strings.Count(stmt, "?") == 3) {
+			t.Fatalf("insert %q mixes column sets", stmt)
+		}
+	}
+}
+
+func TestCreateInBatchesMasterChanged(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 1)
+
+	bt, err := c.batchRoute(reflect.ValueOf(&batchUser{UserID: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := newFakeNode(t, t.Name()+"/new")
+	if err := c.shardingList[0].SetMaster(node); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bt.insert([]*batchRow{{value: reflect.ValueOf(&batchUser{UserID: 1})}}); !errors.Is(err, ErrMasterChanged) {
+		t.Fatalf("insert error = %v, want ErrMasterChanged", err)
+	}
+	if stmts := servers[0].statements("INSERT"); len(stmts) != 0 {
+		t.Fatalf("old master statements = %q, want none", stmts)
+	}
+}
+
+func TestSessionCreateInBatches(t *testing.T) {
+	c, _, master, slave := newRoutingCluster(t)
+	s := c.Session()
+
+	if _, err := s.CreateInBatches([]batchUser{{UserID: 1}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// 会话中写入后的读在 master 上
+	var users []batchUser
+	if err := s.DB(int64(1)).Find(&users).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if len(master.statements("SELECT")) != 1 || len(slave.statements("SELECT")) != 0 {
+		t.Fatalf("master %q, slave %q, want read on master", master.statements(""), slave.statements(""))
+	}
+}
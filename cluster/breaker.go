@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// ReadPolicy slave 读的熔断和重试策略
+type ReadPolicy struct {
+	// FailureThreshold 连续失败多少次后熔断节点，熔断的节点不参与读，0 不熔断
+	FailureThreshold int
+	// OpenTimeout 熔断多久后半开，放一个请求试探，成功后恢复
+	OpenTimeout time.Duration
+	// Retries 失败后最多再尝试几次，每次换一个节点，最后一次在 master 上执行
+	Retries int
+	// Retryable 判断错误是否可以重试，并计入熔断，默认为 Retryable
+	Retryable func(err error) bool
+}
+
+func (p *ReadPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return Retryable(err)
+}
+
+// Retryable 连接失效和超时的错误可以换节点重试
+func Retryable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// breaker 节点的熔断器
+type breaker struct {
+	mtx      sync.Mutex
+	policy   *ReadPolicy
+	state    breakerState
+	failures int
+	changed  time.Time
+}
+
+// ready 节点是否可以读，熔断超时后可以放一个试探请求
+func (b *breaker) ready() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.policy == nil || b.state == breakerClosed {
+		return true
+	}
+
+	// 半开的试探请求没有结果时，超时后再放一个
+	return time.Since(b.changed) >= b.policy.OpenTimeout
+}
+
+// acquire 节点被选中，熔断超时后进入半开
+func (b *breaker) acquire() {
+	if b == nil {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.policy != nil && b.state != breakerClosed && time.Since(b.changed) >= b.policy.OpenTimeout {
+		b.state, b.changed = breakerHalfOpen, time.Now()
+	}
+}
+
+// record 记录查询结果，不可重试的错误说明节点是正常的
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.policy == nil || b.policy.FailureThreshold <= 0 {
+		return
+	}
+
+	if err == nil || !b.policy.retryable(err) {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.policy.FailureThreshold {
+		b.state, b.changed = breakerOpen, time.Now()
+	}
+}
+
+func (n *ClusterNode) breaker() *breaker {
+	if n.state == nil {
+		return nil
+	}
+	return &n.state.breaker
+}
+
+// Read 在 slave 上执行 fn，返回 ReadPolicy 中可以重试的错误时换下一个节点，最后在 master 上执行
+func (n *Sharding) Read(fn func(node *ClusterNode) error) error {
+	node := n.pick()
+	err := fn(n.node(node))
+
+	p := n.opt.readPolicy
+	if p == nil || n.err != nil {
+		return err
+	}
+
+	tried := map[*ClusterNode]bool{node: true}
+	for i := 0; i < p.Retries && err != nil && node != n.Master() && p.retryable(err); i++ {
+		node = n.Master()
+		if i < p.Retries-1 {
+			node = n.next(tried)
+		}
+		tried[node] = true
+		err = fn(n.node(node))
+	}
+	return err
+}
+
+// next 选择一个没有尝试过的节点，和 pick 一样只在会话可以读到写入的节点中选择，没有时返回 master
+func (n *Sharding) next(tried map[*ClusterNode]bool) *ClusterNode {
+	candidates := n.candidates()
+	if n.session != nil {
+		candidates = n.session.filter(n, candidates)
+	}
+
+	var nodes []*ClusterNode
+	for _, s := range candidates {
+		if !tried[s] {
+			nodes = append(nodes, s)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return n.Master()
+	}
+
+	node := n.opt.balancer.Next(nodes)
+	node.breaker().acquire()
+	return node
+}
+
+// read 用 Read 执行返回 *ClusterNode 的查询
+func (n *Sharding) read(fn func(node *ClusterNode) *ClusterNode) *ClusterNode {
+	var res *ClusterNode
+	n.Read(func(node *ClusterNode) error {
+		res = fn(node)
+		return res.Error()
+	})
+	return res
+}
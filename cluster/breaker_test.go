@@ -0,0 +1,199 @@
+package cluster
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{policy: &ReadPolicy{FailureThreshold: 2, OpenTimeout: time.Hour}}
+
+	b.record(driver.ErrBadConn)
+	if b.state != breakerClosed || !b.ready() {
+		t.Fatalf("state after 1 failure = %v, want closed", b.state)
+	}
+
+	// 不可重试的错误说明节点是正常的，重新计数
+	b.record(errors.New("syntax error"))
+	b.record(driver.ErrBadConn)
+	if b.state != breakerClosed {
+		t.Fatalf("state after reset = %v, want closed", b.state)
+	}
+
+	b.record(driver.ErrBadConn)
+	if b.state != breakerOpen || b.ready() {
+		t.Fatalf("state after 2 failures = %v, want open", b.state)
+	}
+
+	// 熔断超时后半开，试探失败重新熔断
+	b.changed = time.Now().Add(-2 * time.Hour)
+	if !b.ready() {
+		t.Fatal("breaker not ready after open timeout")
+	}
+	b.acquire()
+	if b.state != breakerHalfOpen {
+		t.Fatalf("state after acquire = %v, want half open", b.state)
+	}
+	b.record(driver.ErrBadConn)
+	if b.state != breakerOpen {
+		t.Fatalf("state after failed probe = %v, want open", b.state)
+	}
+
+	b.changed = time.Now().Add(-2 * time.Hour)
+	b.acquire()
+	b.record(nil)
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Fatalf("state after probe = %v, failures %d, want closed", b.state, b.failures)
+	}
+}
+
+func TestBreakerWithoutThreshold(t *testing.T) {
+	b := &breaker{policy: &ReadPolicy{}}
+	for i := 0; i < 10; i++ {
+		b.record(driver.ErrBadConn)
+	}
+	if b.state != breakerClosed || !b.ready() {
+		t.Fatalf("state = %v, want closed", b.state)
+	}
+}
+
+func badSelect(query string) error {
+	if strings.HasPrefix(query, "SELECT") {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func TestReadChainedRetry(t *testing.T) {
+	sh, ms, slaves := newFakeSharding(t, "breaker_chain", 2, WithReadPolicy(ReadPolicy{Retries: 2}))
+	for _, s := range slaves {
+		s.setHook(badSelect)
+	}
+
+	var users []tagUser
+	if err := sh.Where("user_id = ?", 1).Order("id").Limit(10).Find(&users).Error(); err != nil {
+		t.Fatalf("Find error = %v", err)
+	}
+
+	for i, s := range slaves {
+		if len(s.statements("user_id")) == 0 {
+			t.Errorf("slave%d not tried", i)
+		}
+	}
+	stmts := ms.statements("SELECT")
+	if len(stmts) != 1 || !strings.Contains(stmts[0], "user_id") || !strings.Contains(stmts[0], "LIMIT 10") {
+		t.Fatalf("master statements = %q, want the chained query", stmts)
+	}
+}
+
+func TestReadChainedBreaker(t *testing.T) {
+	sh, ms, slaves := newFakeSharding(t, "breaker_open", 1,
+		WithReadPolicy(ReadPolicy{FailureThreshold: 1, OpenTimeout: time.Hour, Retries: 1}))
+	slaves[0].setHook(badSelect)
+
+	var users []tagUser
+	if err := sh.Where("user_id = ?", 1).Find(&users).Error(); err != nil {
+		t.Fatalf("Find error = %v", err)
+	}
+	tried := len(slaves[0].statements("SELECT"))
+
+	// slave 已经熔断，直接在 master 上读
+	var n int64
+	if err := sh.Model(&tagUser{}).Where("user_id = ?", 1).Count(&n).Error(); err != nil {
+		t.Fatalf("Count error = %v", err)
+	}
+	if got := len(slaves[0].statements("SELECT")); got != tried {
+		t.Errorf("open slave got %d more statements", got-tried)
+	}
+	if got := len(ms.statements("count(")); got != 1 {
+		t.Errorf("master count statements = %d, want 1", got)
+	}
+}
+
+// ScanRows 和跨库查询选择节点时不经过 Read，不能把熔断的节点切到半开
+func TestSlaveKeepsBreakerOpen(t *testing.T) {
+	sh, _, _ := newFakeSharding(t, t.Name(), 1, WithReadPolicy(ReadPolicy{FailureThreshold: 1, OpenTimeout: time.Hour}))
+	b := sh.replicas()[0].breaker()
+	b.record(driver.ErrBadConn)
+	b.changed = time.Now().Add(-2 * time.Hour)
+
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	if err := c.Model(&tagUser{}).Count(&n); err != nil {
+		t.Fatal(err)
+	}
+	sh.slave()
+
+	if b.state != breakerOpen {
+		t.Fatalf("state = %v, want open", b.state)
+	}
+}
+
+func TestReadChainedNotRetryable(t *testing.T) {
+	sh, ms, slaves := newFakeSharding(t, "breaker_syntax", 1, WithReadPolicy(ReadPolicy{Retries: 1}))
+	slaves[0].setHook(func(query string) error {
+		return errors.New("syntax error")
+	})
+
+	var users []tagUser
+	if err := sh.Where("user_id = ?", 1).Find(&users).Error(); err == nil {
+		t.Fatal("Find succeeded, want slave error")
+	}
+	if stmts := ms.statements("SELECT"); len(stmts) != 0 {
+		t.Fatalf("master statements = %q, want none", stmts)
+	}
+}
+
+func TestReadRetryKeepsSession(t *testing.T) {
+	sh, ms, slaves := newFakeSharding(t, t.Name(), 2, WithReadPolicy(ReadPolicy{Retries: 3}))
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Session(WithGTIDWait())
+
+	ms.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "gtid_executed") {
+			return []string{"gtid"}, [][]driver.Value{{"uuid:1-5"}}
+		}
+		return nil, nil
+	})
+	// slave0 追上了写入但是连接失效，slave1 没有追上
+	for i, applied := range []int64{1, 0} {
+		applied := applied
+		slaves[i].setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			if strings.Contains(query, "GTID_SUBSET") {
+				return []string{"subset"}, [][]driver.Value{{applied}}
+			}
+			return nil, nil
+		})
+	}
+	slaves[0].setHook(func(query string) error {
+		if strings.Contains(query, "tag_user") {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+
+	s.wrote(sh)
+	var users []tagUser
+	if err := s.DB(int64(1)).Find(&users).Error(); err != nil {
+		t.Fatalf("Find error = %v", err)
+	}
+
+	if len(slaves[0].statements("tag_user")) == 0 {
+		t.Fatal("caught up slave not tried")
+	}
+	if stmts := slaves[1].statements("tag_user"); len(stmts) != 0 {
+		t.Fatalf("retry read from lagging slave: %q", stmts)
+	}
+	if len(ms.statements("tag_user")) != 1 {
+		t.Fatal("retry did not fall back to master")
+	}
+}
@@ -1,12 +1,23 @@
 package cluster
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 )
 
 type Cluster struct {
 	opt          Options
 	shardingList []*Sharding
+	ctx          context.Context
+	segment      *lazySegment
+}
+
+// lazySegment NextID 的号段分配器，没有配置时在第一次 NextID 时创建，WithContext 返回的 Cluster 共用
+type lazySegment struct {
+	once      sync.Once
+	allocator *SegmentAllocator
 }
 
 func (c *Cluster) DBNum() int {
@@ -20,47 +31,117 @@ func (c *Cluster) TableNum() int {
 // values sharding 的值
 func (c *Cluster) DB(values ...interface{}) *Sharding {
 	// 根据value get shard 计算库
-	idx := c.opt.selector.Number(c.opt.dbNum, values...)
-	if int(idx) >= len(c.shardingList) {
-		panic(fmt.Sprintf("selecter db num more than max number:%v max:%v", idx, len(c.shardingList)))
+	idx, err := c.dbIndex(values...)
+
+	// 路由失败时借用第一个库的连接把错误带给后续操作
+	if err != nil {
+		idx = 0
 	}
 
 	sh := c.shardingList[idx].clone()
 	sh.ShardingValues = values
+	sh.err = err
+	sh.ctx = c.ctx
 	return sh
 }
 
+func (c *Cluster) dbIndex(values ...interface{}) (uint64, error) {
+	idx, err := c.routeDB(values...)
+	if err == nil && int(idx) >= len(c.shardingList) {
+		err = fmt.Errorf("%w: db index %v max:%v", ErrShardOutOfRange, idx, len(c.shardingList))
+	}
+	return idx, err
+}
+
+func (c *Cluster) routeDB(values ...interface{}) (idx uint64, err error) {
+	if router, ok := c.opt.selector.(DBRouter); ok {
+		return router.RouteDB(c.opt.dbNum, values...)
+	}
+
+	// 只实现了 DBSelector 的选择器用 panic 表示不支持的值
+	defer func() {
+		if r := recover(); r != nil {
+			err = selectorError(r)
+		}
+	}()
+	return c.opt.selector.Number(c.opt.dbNum, values...), nil
+}
+
+// Route 根据 value 中 `gorm-cluster:"shard_key"` 字段的值选择库
+func (c *Cluster) Route(value interface{}) *Sharding {
+	key, err := ShardKey(value)
+	if err != nil {
+		sh := c.shardingList[0].clone()
+		sh.err = err
+		sh.ctx = c.ctx
+		return sh
+	}
+	return c.DB(key)
+}
+
 func (c *Cluster) Sharding(fn func(sharding *Sharding)) {
 	for _, s := range c.shardingList {
 		fn(s)
 	}
 }
 
+// NextID 返回 name 序列的下一个全局唯一 ID
+func (c *Cluster) NextID(ctx context.Context, name string) (int64, error) {
+	if c.segment == nil {
+		return 0, errors.New("cluster has no segment allocator")
+	}
+
+	c.segment.once.Do(func() {
+		if c.segment.allocator == nil {
+			c.segment.allocator = NewSegmentAllocator(c.shardingList[0])
+		}
+	})
+	return c.segment.allocator.NextID(ctx, name)
+}
+
 func (c *Cluster) SetDBSelector(selector DBSelector) {
 	c.opt.selector = selector
 }
 
-func NewCluster(opts ...Option) *Cluster {
+// NewCluster 创建 Cluster，至少需要一个 Sharding
+func NewCluster(opts ...Option) (*Cluster, error) {
 	var opt Options
 	for _, o := range opts {
 		o(&opt)
 	}
 
+	if len(opt.sharding) == 0 {
+		return nil, fmt.Errorf("%w: must has sharding", ErrInvalidConfig)
+	}
+
 	if opt.dbNum == 0 {
 		opt.dbNum = 1
 	}
 
 	if opt.selector == nil {
-		opt.selector = DBSelectorFunc(dbSelector)
+		opt.selector = defaultDBSelector{}
+	}
+
+	if opt.fanout <= 0 {
+		opt.fanout = 16
+	}
+
+	// 复制调用方的 Sharding 记录库数量，master、slave 和会话仍然和原来的 Sharding 共享
+	shardings := make([]*Sharding, len(opt.sharding))
+	for i, sh := range opt.sharding {
+		shardings[i] = sh.derive()
+		shardings[i].dbNum = opt.dbNum
 	}
+	opt.sharding = shardings
 
 	return &Cluster{
 		opt:          opt,
 		shardingList: opt.sharding,
-	}
+		segment:      &lazySegment{allocator: opt.sequence},
+	}, nil
 }
 
-func NewClusterWithConfig(config *GormClusterConfig) *Cluster {
+func NewClusterWithConfig(config *GormClusterConfig) (*Cluster, error) {
 	var shardings []*Sharding
 	if config.DBNum == 0 {
 		config.DBNum = 1
@@ -71,20 +152,41 @@ func NewClusterWithConfig(config *GormClusterConfig) *Cluster {
 	}
 
 	for i := 0; i < config.DBNum && len(config.Sharding) == 0; i++ {
-		shardings = append(shardings, config.ShardingDB(i))
+		sh, err := config.ShardingDB(i)
+		if err != nil {
+			return nil, err
+		}
+		shardings = append(shardings, sh)
 	}
 
 	for _, master := range config.Sharding {
 		master.TableNum = config.TableNum
 		master.DBNum = config.DBNum
-		fmt.Printf("db %v %v\n", master.DataSource, master.DBIndex)
-
-		shardings = append(shardings, master.ShardingDB(master.DBIndex))
+		master.Ranges = config.Ranges
+		if master.MaxLag == 0 {
+			master.MaxLag = config.MaxLag
+		}
+		sh, err := master.ShardingDB(master.DBIndex)
+		if err != nil {
+			return nil, err
+		}
+		shardings = append(shardings, sh)
 	}
 
-	return NewCluster(
+	opts := []Option{
 		WithDBNum(config.DBNum),
 		WithTables(int(config.TableNum)),
 		WithShardings(shardings...),
-	)
+		WithShardColumn(config.ShardColumn),
+	}
+
+	if len(config.Ranges) > 0 {
+		selector, err := NewRangeSharding(config.Ranges...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithDBSelector(selector))
+	}
+
+	return NewCluster(opts...)
 }
@@ -1,7 +1,9 @@
 package cluster
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,17 +11,25 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 )
 
+// ErrNotOpen 节点还没有 Open 或者 Open 失败
+var ErrNotOpen = errors.New("cluster node is not open")
+
 type ClusterNode struct {
-	db   *gorm.DB
-	opts NodeOptions
+	db    *gorm.DB
+	opts  NodeOptions
+	state *nodeState
+	// derived Model 的表是多张表的 UNION ALL 子查询，只能读
+	derived bool
+	// scopes 从连接池创建节点之后应用的链式条件
+	scopes []func(*gorm.DB) *gorm.DB
 
 	ShardingValues []interface{}
 }
 
-func NewClusterNode(opts ...NodeOption) *ClusterNode {
+func NewClusterNode(opts ...NodeOption) (*ClusterNode, error) {
 	opt := NodeOptions{
 		tableNum:      1,
-		tableSelector: TableSelectorFunc(tableSelector),
+		tableSelector: defaultTableSelector{},
 	}
 
 	for _, o := range opts {
@@ -27,7 +37,7 @@ func NewClusterNode(opts ...NodeOption) *ClusterNode {
 	}
 
 	if opt.db == nil {
-		panic("db option nil")
+		return nil, fmt.Errorf("%w: db option nil", ErrInvalidConfig)
 	}
 
 	if opt.db.MaxIdleConns == 0 {
@@ -47,14 +57,60 @@ func NewClusterNode(opts ...NodeOption) *ClusterNode {
 	}
 
 	return &ClusterNode{
-		opts: opt,
+		opts:  opt,
+		state: &nodeState{lag: lagUnknown},
+	}, nil
+}
+
+// withError 返回一个携带 err 的节点，后续的操作都会直接返回该错误
+func (n *ClusterNode) withError(err error) *ClusterNode {
+	var db *gorm.DB
+	if n.db != nil {
+		db = n.db.New()
+	} else {
+		db = n.closedDB()
+	}
+	db.AddError(err)
+	return &ClusterNode{db: db, opts: n.opts, ShardingValues: n.ShardingValues}
+}
+
+// chain 在 n.db 上应用 fn 返回继续构造查询的节点，保留分表的路由信息，
+// fn 记录在 scopes 中，WithContext 时在新的 gorm.DB 上重新应用
+func (n *ClusterNode) chain(fn func(*gorm.DB) *gorm.DB) *ClusterNode {
+	cn := n.rebind(fn(n.db))
+	cn.scopes = append(n.scopes[:len(n.scopes):len(n.scopes)], fn)
+	return cn
+}
+
+// rebind 返回使用 db 的节点，保留分表的路由信息和 scopes
+func (n *ClusterNode) rebind(db *gorm.DB) *ClusterNode {
+	return &ClusterNode{db: db, opts: n.opts, state: n.state, derived: n.derived, scopes: n.scopes, ShardingValues: n.ShardingValues}
+}
+
+// canWrite 写入前检查 master 是否已经切换，以及表是否可以写入
+func (n *ClusterNode) canWrite() error {
+	if n.retired() {
+		return ErrMasterChanged
+	}
+
+	if n.derived {
+		return fmt.Errorf("%w: can not write to union of tables", ErrMultipleTables)
 	}
+	return nil
 }
 
 func (n *ClusterNode) SetTableSelector(selector TableSelector) {
 	n.opts.tableSelector = selector
 }
 
+// Weight 节点的权重，没有配置时为 1
+func (n *ClusterNode) Weight() int {
+	if n.opts.db == nil || n.opts.db.Weight <= 0 {
+		return 1
+	}
+	return n.opts.db.Weight
+}
+
 func (n *ClusterNode) TableNum() int {
 	return int(n.opts.tableNum)
 }
@@ -64,21 +120,27 @@ func (n *ClusterNode) Master() bool {
 }
 
 func (n *ClusterNode) Open() error {
-	if n.opts.db.DataSource == "" {
-		n.opts.db.DataSource = fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=true&loc=Local",
-			n.opts.db.UserName,
-			n.opts.db.Password,
-			fmt.Sprintf("%v:%v", n.opts.db.Host, n.opts.db.Port),
-			n.opts.db.DBName,
-		)
+	return n.OpenContext(context.Background())
+}
+
+// OpenContext 连接数据库，ctx 控制第一次 ping 的超时
+func (n *ClusterNode) OpenContext(ctx context.Context) error {
+	n.opts.db.DataSource = n.opts.db.dsn()
+	n.opts.db.Driver = n.opts.db.driver()
+
+	pool, err := sql.Open(n.opts.db.Driver, n.opts.db.DataSource)
+	if err != nil {
+		return err
 	}
 
-	if n.opts.db.Driver == "" {
-		n.opts.db.Driver = "mysql"
+	if err := pool.PingContext(ctx); err != nil {
+		pool.Close()
+		return err
 	}
 
-	db, err := gorm.Open(n.opts.db.Driver, n.opts.db.DataSource)
+	db, err := gorm.Open(n.opts.db.Driver, pool)
 	if err != nil {
+		pool.Close()
 		return err
 	}
 
@@ -89,34 +151,155 @@ func (n *ClusterNode) Open() error {
 	return nil
 }
 
+// shardingValues 没有指定 sharding 值时从 value 的 shard_key 字段获取，没有 shard_key 或者为零值时返回错误，
+// 只有一个库一张表时不需要路由，可以没有 shard_key
+func (n *ClusterNode) shardingValues(value interface{}) ([]interface{}, error) {
+	if len(n.ShardingValues) > 0 {
+		return n.ShardingValues, nil
+	}
+
+	key, err := ShardKey(value)
+	if (errors.Is(err, ErrNoShardKey) || errors.Is(err, ErrZeroShardKey)) && n.unsharded() {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{key}, nil
+}
+
+func (n *ClusterNode) unsharded() bool {
+	return n.opts.tableNum <= 1 && n.opts.dbNum <= 1
+}
+
+// routeTable 返回 value 所在的表，不需要路由时返回空字符串，value 可以是 model 的 slice
+func (n *ClusterNode) routeTable(value interface{}) (string, error) {
+	// Model 等返回的节点已经确定了表
+	if n.opts.tableSelector == nil {
+		return "", nil
+	}
+
+	values, err := n.shardingValues(value)
+	if err != nil || len(values) == 0 {
+		return "", err
+	}
+
+	sv := ShardingValue{value: newElem(value), shradingValues: values,
+		tableNum: n.opts.tableNum, dbIndex: n.opts.dbIndex, tableSelector: n.opts.tableSelector}
+	return sv.RouteTableName()
+}
+
+// table 返回路由到 value 所在表的 gorm.DB，查询的结果如 []User 没有 shard_key，不分表时直接查询
+func (n *ClusterNode) table(value interface{}) (*gorm.DB, error) {
+	name, err := n.routeTable(value)
+	if (errors.Is(err, ErrNoShardKey) || errors.Is(err, ErrZeroShardKey)) && n.opts.tableNum <= 1 {
+		return n.db, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		return n.db, nil
+	}
+	return n.db.Table(name), nil
+}
+
+// writeTable 同 table，value 覆盖多张表时不能写入
+func (n *ClusterNode) writeTable(value interface{}) (*gorm.DB, error) {
+	if err := n.canWrite(); err != nil {
+		return nil, err
+	}
+
+	name, err := n.routeTable(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if derivedTable(name) {
+		return nil, fmt.Errorf("%w: can not write %T to union of tables", ErrMultipleTables, value)
+	}
+
+	if name == "" {
+		return n.db, nil
+	}
+	return n.db.Table(name), nil
+}
+
+// tables 返回该库上 originName 的所有物理表
+func (n *ClusterNode) tables(originName string) ([]string, error) {
+	if lister, ok := n.opts.tableSelector.(TableLister); ok {
+		return lister.Tables(originName, n.opts.tableNum, n.opts.dbIndex)
+	}
+	return defaultTables(originName, n.opts.tableNum, n.opts.dbIndex), nil
+}
+
 // Save update value in database, if the value doesn't have primary key, will insert it
 func (n *ClusterNode) Save(value interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Save(value)}
+	db, err := n.writeTable(value)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.Save(value)), state: n.state}
 }
 
 // Create insert the value into database
 func (n *ClusterNode) Create(value interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Create(value)}
+	db, err := n.writeTable(value)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.Create(value)), state: n.state}
 }
 
 // Delete delete value match given conditions, if the value has primary key, then will including the primary key as condition
 func (n *ClusterNode) Delete(value interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Delete(value)}
+	db, err := n.writeTable(value)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.Delete(value)), state: n.state}
 }
 
 // Scan scan value to a struct
 func (n *ClusterNode) Scan(dest interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Scan(dest)}
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.Scan(dest)), state: n.state}
 }
 
 // Row return `*sql.Row` with given conditions
 func (n *ClusterNode) Row() *sql.Row {
-	return n.db.Row()
+	// gorm 不检查已有的错误，路由失败时不能在借用的库上执行
+	if n.db.Error != nil {
+		return errRow(n.db.Error)
+	}
+
+	start := time.Now()
+	row := n.db.Row()
+	if row != nil {
+		n.record(time.Since(start), row.Err())
+	}
+	return row
 }
 
 // Rows return `*sql.Rows` with given conditions
 func (n *ClusterNode) Rows() (*sql.Rows, error) {
-	return n.db.Rows()
+	if n.db.Error != nil {
+		return nil, n.db.Error
+	}
+
+	start := time.Now()
+	rows, err := n.db.Rows()
+	n.record(time.Since(start), err)
+	return rows, err
 }
 
 // ScanRows scan `*sql.Rows` to give struct
@@ -127,54 +310,102 @@ func (n *ClusterNode) ScanRows(rows *sql.Rows, result interface{}) error {
 // FirstOrCreate find first matched record or create a new one with given conditions (only works with struct, map conditions)
 // https://jinzhu.github.io/gorm/crud.html#firstorcreate
 func (n *ClusterNode) FirstOrCreate(out interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.FirstOrCreate(out)}
+	db, err := n.writeTable(out)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.FirstOrCreate(out)), state: n.state}
 }
 
 // First find first record that match given conditions, order by primary key
 func (n *ClusterNode) First(out interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.First(out)}
+	db, err := n.table(out)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.First(out)), state: n.state}
 }
 
+// Last find last record that match given conditions, order by primary key
 func (n *ClusterNode) Last(out interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Last(out)}
+	db, err := n.table(out)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.Last(out)), state: n.state}
 }
 
 // Updates update attributes with callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
 func (n *ClusterNode) Updates(values interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Updates(values)}
+	if err := n.canWrite(); err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.Updates(values)), state: n.state}
 }
 
 // UpdateColumns update attributes without callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
 func (n *ClusterNode) UpdateColumns(values interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.UpdateColumns(values)}
+	if err := n.canWrite(); err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.UpdateColumns(values)), state: n.state}
 }
 
 // Begin begin a transaction
 func (n *ClusterNode) Begin() *ClusterNode {
-	return &ClusterNode{db: n.db.Begin()}
+	if err := n.canWrite(); err != nil {
+		return n.withError(err)
+	}
+
+	return n.rebind(n.db.Begin())
 }
 
 // Commit commit a transaction
 func (n *ClusterNode) Commit() *ClusterNode {
-	return &ClusterNode{db: n.db.Commit()}
+	return n.rebind(n.db.Commit())
 }
 
 // Rollback rollback a transaction
 func (n *ClusterNode) Rollback() *ClusterNode {
-	return &ClusterNode{db: n.db.Rollback()}
+	return n.rebind(n.db.Rollback())
 }
 
 // Find find records that match given conditions
 func (n *ClusterNode) Find(out interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Find(out)}
+	db, err := n.table(out)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.Find(out)), state: n.state}
 }
 
+// Raw use raw sql as conditions, won't run it unless invoked by other methods
+//
+//	db.Raw("SELECT name, age FROM users WHERE name = ?", 3).Scan(&result)
 func (n *ClusterNode) Raw(sql string, values ...interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Raw(sql, values...)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Raw(sql, values...) })
 }
 
+// Exec execute raw sql
 func (n *ClusterNode) Exec(sql string, values ...interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Exec(sql, values...)}
+	if err := n.canWrite(); err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.Exec(sql, values...)), state: n.state}
 }
 
 func (n *ClusterNode) Error() error {
@@ -183,27 +414,27 @@ func (n *ClusterNode) Error() error {
 
 // Where return a new relation, filter records with given conditions, accepts `map`, `struct` or `string` as conditions, refer http://jinzhu.github.io/gorm/crud.html#query
 func (n *ClusterNode) Where(query interface{}, args ...interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Where(query, args...)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Where(query, args...) })
 }
 
 // Or filter records that match before conditions or this one, similar to `Where`
 func (n *ClusterNode) Or(query interface{}, args ...interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Or(query, args...)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Or(query, args...) })
 }
 
 // Not filter records that don't match current conditions, similar to `Where`
 func (n *ClusterNode) Not(query interface{}, args ...interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Not(query, args...)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Not(query, args...) })
 }
 
 // Limit specify the number of records to be retrieved
 func (n *ClusterNode) Limit(limit interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Limit(limit)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Limit(limit) })
 }
 
 // Offset specify the number of records to skip before starting to return the records
 func (n *ClusterNode) Offset(offset interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Offset(offset)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Offset(offset) })
 }
 
 // Model specify the model you would like to run db operations
@@ -212,9 +443,18 @@ func (n *ClusterNode) Offset(offset interface{}) *ClusterNode {
 //    // if user's primary key is non-blank, will use it as condition, then will only update the user's name to `hello`
 //    db.Model(&user).Update("name", "hello")
 func (n *ClusterNode) Model(value interface{}) *ClusterNode {
-	sv := ShardingValue{value: value, shradingValues: n.ShardingValues,
-		tableNum: n.opts.tableNum, dbIndex: n.opts.dbIndex, tableSelector: n.opts.tableSelector}
-	return &ClusterNode{db: n.db.Table(sv.TableName()).Model(value)}
+	name, err := n.routeTable(value)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	fn := func(db *gorm.DB) *gorm.DB {
+		if name != "" {
+			db = db.Table(name)
+		}
+		return db.Model(value)
+	}
+	return &ClusterNode{db: fn(n.db), state: n.state, derived: derivedTable(name), scopes: append(n.scopes[:len(n.scopes):len(n.scopes)], fn)}
 }
 
 // Order specify order when retrieve records from database, set reorder to `true` to overwrite defined conditions
@@ -222,20 +462,139 @@ func (n *ClusterNode) Model(value interface{}) *ClusterNode {
 //     db.Order("name DESC", true) // reorder
 //     db.Order(gorm.Expr("name = ? DESC", "first")) // sql expression
 func (n *ClusterNode) Order(value interface{}, reorder ...bool) *ClusterNode {
-	return &ClusterNode{db: n.db.Order(value, reorder...)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Order(value, reorder...) })
 }
 
 // Select specify fields that you want to retrieve from database when querying, by default, will select all fields;
 // When creating/updating, specify fields that you want to save to database
 func (n *ClusterNode) Select(query interface{}, args ...interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Select(query, args...)}
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Select(query, args...) })
 }
 
 // Count get how many records for a model
 func (n *ClusterNode) Count(value interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db.Count(value)}
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.Count(value)), state: n.state}
 }
 
 func (n *ClusterNode) Table(values ...interface{}) *ClusterNode {
-	return &ClusterNode{db: n.db, opts: n.opts, ShardingValues: values}
+	return &ClusterNode{db: n.db, opts: n.opts, state: n.state, scopes: n.scopes, ShardingValues: values}
+}
+
+// Update update attributes with callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
+func (n *ClusterNode) Update(attrs ...interface{}) *ClusterNode {
+	if err := n.canWrite(); err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.Update(attrs...)), state: n.state}
 }
+
+// UpdateColumn update attributes without callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
+func (n *ClusterNode) UpdateColumn(attrs ...interface{}) *ClusterNode {
+	if err := n.canWrite(); err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.UpdateColumn(attrs...)), state: n.state}
+}
+
+// Pluck used to query single column from a model as a map
+//
+//	var ages []int64
+//	db.Find(&users).Pluck("age", &ages)
+func (n *ClusterNode) Pluck(column string, value interface{}) *ClusterNode {
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, n.db.Pluck(column, value)), state: n.state}
+}
+
+// Take return a record that match given conditions, the order will depend on the database implementation
+func (n *ClusterNode) Take(out interface{}) *ClusterNode {
+	db, err := n.table(out)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.Take(out)), state: n.state}
+}
+
+// FirstOrInit find first matched record or initialize a new one with given conditions (only works with struct, map conditions)
+// https://jinzhu.github.io/gorm/crud.html#firstorinit
+func (n *ClusterNode) FirstOrInit(out interface{}) *ClusterNode {
+	db, err := n.table(out)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.FirstOrInit(out)), state: n.state}
+}
+
+// Related get related associations
+func (n *ClusterNode) Related(value interface{}, foreignKeys ...string) *ClusterNode {
+	db, err := n.table(value)
+	if err != nil {
+		return n.withError(err)
+	}
+
+	start := time.Now()
+	return &ClusterNode{db: n.observe(start, db.Related(value, foreignKeys...)), state: n.state}
+}
+
+// Group specify the group method on the find
+func (n *ClusterNode) Group(query string) *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Group(query) })
+}
+
+// Having specify HAVING conditions for GROUP BY
+func (n *ClusterNode) Having(query interface{}, values ...interface{}) *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Having(query, values...) })
+}
+
+// Joins specify Joins conditions
+//
+//	db.Joins("JOIN emails ON emails.user_id = users.id AND emails.email = ?", "jinzhu@example.org").Find(&user)
+func (n *ClusterNode) Joins(query string, args ...interface{}) *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Joins(query, args...) })
+}
+
+// Preload preload associations with given conditions
+//
+//	db.Preload("Orders", "state NOT IN (?)", "cancelled").Find(&users)
+func (n *ClusterNode) Preload(column string, conditions ...interface{}) *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Preload(column, conditions...) })
+}
+
+// Unscoped return all record including deleted record, refer Soft Delete https://jinzhu.github.io/gorm/crud.html#soft-delete
+func (n *ClusterNode) Unscoped() *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Unscoped() })
+}
+
+// Attrs initialize struct with argument if record not found with given conditions, refer https://jinzhu.github.io/gorm/crud.html#firstorinit
+func (n *ClusterNode) Attrs(attrs ...interface{}) *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Attrs(attrs...) })
+}
+
+// Assign assign result with argument regardless it is found or not with FirstOrInit https://jinzhu.github.io/gorm/crud.html#firstorinit or FirstOrCreate https://jinzhu.github.io/gorm/crud.html#firstorcreate
+func (n *ClusterNode) Assign(attrs ...interface{}) *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Assign(attrs...) })
+}
+
+// Set set setting by name, which could be used in callbacks, will clone a new db, and update its setting
+func (n *ClusterNode) Set(name string, value interface{}) *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Set(name, value) })
+}
+
+// Get get setting by name
+func (n *ClusterNode) Get(name string) (value interface{}, ok bool) {
+	return n.db.Get(name)
+}
+
+// Debug start debug mode
+func (n *ClusterNode) Debug() *ClusterNode {
+	return n.chain(func(db *gorm.DB) *gorm.DB { return db.Debug() })
+}
+
@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type nodeUser struct {
+	ID   int64
+	Name string
+}
+
+func (nodeUser) TableName() string {
+	return "node_user"
+}
+
+func TestNodeNotOpen(t *testing.T) {
+	node, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.Ping(); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("ping got %v", err)
+	}
+
+	cause := errors.New("route failed")
+	var users []nodeUser
+	if err := node.withError(cause).Find(&users).Error(); !errors.Is(err, cause) {
+		t.Fatalf("find got %v", err)
+	}
+
+	sh, err := NewSharding(WithMaster(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sh.Find(&users).Error(); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("sharding find got %v", err)
+	}
+
+	if err := sh.WithContext(context.Background()).Find(&users).Error(); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("sharding find with context got %v", err)
+	}
+
+	// Count 通过 Row 查询，closedDB 返回携带 ErrNotOpen 的 Row 而不是 nil
+	var n int64
+	if err := node.withError(cause).Model(&nodeUser{}).Count(&n).Error(); err == nil || !strings.Contains(err.Error(), cause.Error()) {
+		t.Fatalf("count got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestNewClusterWithoutSharding(t *testing.T) {
+	if _, err := NewCluster(WithDBNum(2)); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("NewCluster error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+// 同一个 Sharding 可以放在不同的 Cluster 中，库数量不能写到调用方的节点上
+func TestNewClusterKeepsSharding(t *testing.T) {
+	sh, _, _ := newFakeSharding(t, t.Name(), 0)
+	c, err := NewCluster(WithDBNum(2), WithShardings(sh, sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sh.Master().opts.dbNum != 0 || sh.primary().opts.dbNum != 0 {
+		t.Fatal("NewCluster changed the caller's sharding")
+	}
+	if got := c.shardingList[0].primary().opts.dbNum; got != 2 {
+		t.Fatalf("cluster node dbNum = %d, want 2", got)
+	}
+	if c.shardingList[0].Master() != sh.Master() {
+		t.Fatal("cluster sharding does not share the master")
+	}
+}
+
+func TestRouteDBSelectorPanic(t *testing.T) {
+	c, _ := newFakeCluster(t, t.Name(), 2, WithDBSelector(DBSelectorFunc(func(num int, values ...interface{}) uint64 {
+		if _, ok := values[0].(string); ok {
+			panic("string key")
+		}
+		return uint64(values[0].(int64)) % uint64(num)
+	})))
+
+	if err := c.DB("a").Error(); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("DB error = %v, want ErrUnsupportedKeyType", err)
+	}
+	if err := c.DB(int64(1)).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 选择器自身的 bug 不能被当作不支持的值
+	defer func() {
+		if _, ok := recover().(runtime.Error); !ok {
+			t.Fatal("runtime error was not re-panicked")
+		}
+	}()
+	c.DB(1.5)
+}
+
+func TestShardingValueTableNameError(t *testing.T) {
+	sv := &ShardingValue{value: &conditionUser{}, tableSelector: defaultTableSelector{}, tableNum: 1}
+	if name := sv.TableName(); name != "" {
+		t.Fatalf("TableName = %q, want empty", name)
+	}
+	if !errors.Is(sv.Err(), ErrNoTableName) {
+		t.Fatalf("Err = %v, want ErrNoTableName", sv.Err())
+	}
+
+	sv = &ShardingValue{value: &tagUser{}, tableSelector: TableSelectorFunc(func(string, uint64, int, ...interface{}) string {
+		var tables []string
+		return tables[1]
+	}), tableNum: 2, shradingValues: []interface{}{int64(1)}}
+	defer func() {
+		if _, ok := recover().(runtime.Error); !ok {
+			t.Fatal("runtime error was not re-panicked")
+		}
+	}()
+	sv.RouteTableName()
+}
+
+func TestRouteErrorDoesNotQuery(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	rows, err := c.DB("not-int64").Raw("SELECT * FROM secret").Rows()
+	if !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("Rows error = %v, want ErrUnsupportedKeyType", err)
+	}
+	if rows != nil {
+		t.Fatal("Rows returned rows on routing error")
+	}
+
+	if err := c.DB("not-int64").Raw("SELECT * FROM secret").Row().Err(); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("Row error = %v, want ErrUnsupportedKeyType", err)
+	}
+
+	node := c.shardingList[0].Master().withError(ErrUnsupportedKeyType).Raw("SELECT * FROM secret")
+	if _, err := node.Rows(); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("node Rows error = %v, want ErrUnsupportedKeyType", err)
+	}
+	if err := node.Row().Err(); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("node Row error = %v, want ErrUnsupportedKeyType", err)
+	}
+
+	for i, s := range servers {
+		if stmts := s.statements("secret"); len(stmts) != 0 {
+			t.Fatalf("db %d ran %v", i, stmts)
+		}
+	}
+}
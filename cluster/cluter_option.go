@@ -1,16 +1,39 @@
 package cluster
 
+import "fmt"
+
 type Options struct {
 	dbNum    int
 	tableNum int
 	selector DBSelector
 	sharding []*Sharding
+
+	shardColumn string
+	noKeyPolicy NoShardKeyPolicy
+	fanout      int
+
+	sequence *SegmentAllocator
 }
 
+// NoShardKeyPolicy 查询条件中没有 sharding 列时的处理方式
+type NoShardKeyPolicy int
+
+const (
+	// Broadcast 查询所有库的所有表
+	Broadcast NoShardKeyPolicy = iota
+	// Reject 返回 ErrNoShardKey
+	Reject
+)
+
 type DBSelector interface {
 	Number(num int, values ...interface{}) uint64
 }
 
+// DBRouter 可以返回路由错误的 DBSelector，Cluster.DB 会优先使用 RouteDB
+type DBRouter interface {
+	RouteDB(num int, values ...interface{}) (uint64, error)
+}
+
 type Option func(*Options)
 
 func WithDBNum(num int) Option {
@@ -37,25 +60,67 @@ func WithShardings(sharding ...*Sharding) Option {
 	}
 }
 
+// WithShardColumn Cluster.Where 等从查询条件中解析该列的值来路由
+func WithShardColumn(column string) Option {
+	return func(o *Options) {
+		o.shardColumn = column
+	}
+}
+
+func WithNoShardKeyPolicy(policy NoShardKeyPolicy) Option {
+	return func(o *Options) {
+		o.noKeyPolicy = policy
+	}
+}
+
+// WithFanout 跨表查询时最多同时查询多少张表，默认 16
+func WithFanout(n int) Option {
+	return func(o *Options) {
+		o.fanout = n
+	}
+}
+
+// WithSegmentAllocator Cluster.NextID 使用的号段分配器，默认使用第一个库上的 sequences 表
+func WithSegmentAllocator(allocator *SegmentAllocator) Option {
+	return func(o *Options) {
+		o.sequence = allocator
+	}
+}
+
 type DBSelectorFunc func(num int, values ...interface{}) uint64
 
 func (d DBSelectorFunc) Number(num int, values ...interface{}) uint64 {
 	return d(num, values...)
 }
 
-func dbSelector(num int, values ...interface{}) uint64 {
+// defaultDBSelector 默认按 int64 取模分库，Cluster 使用 RouteDB，Number 只为实现 DBSelector
+type defaultDBSelector struct{}
+
+func (defaultDBSelector) Number(num int, values ...interface{}) uint64 {
+	idx, err := dbSelector(num, values...)
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+func (defaultDBSelector) RouteDB(num int, values ...interface{}) (uint64, error) {
+	return dbSelector(num, values...)
+}
+
+func dbSelector(num int, values ...interface{}) (uint64, error) {
 	if num == 1 {
-		return 0
+		return 0, nil
 	}
 
 	if len(values) != 1 {
-		panic("default sharding db values len must be 1")
+		return 0, fmt.Errorf("%w: default sharding db values len must be 1", ErrNoShardKey)
 	}
 
 	value, ok := values[0].(int64)
 	if !ok {
-		panic("value must be int64")
+		return 0, fmt.Errorf("%w: value must be int64, got %T", ErrUnsupportedKeyType, values[0])
 	}
 
-	return uint64(uint64(value) % uint64(num))
+	return uint64(uint64(value) % uint64(num)), nil
 }
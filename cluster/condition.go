@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	andRe       = regexp.MustCompile(`(?i)\s+and\s+`)
+	orRe        = regexp.MustCompile(`(?i)\bor\b`)
+	predicateRe = regexp.MustCompile("(?i)^(?:`?\\w+`?\\.)?`?(\\w+)`?\\s*(=|!=|<>|not\\s+in|in)\\s*(.+)$")
+	identRe     = regexp.MustCompile("^`?[A-Za-z_]\\w*`?(?:\\.`?[A-Za-z_]\\w*`?)?$")
+)
+
+// conditionKeys 解析 Where/Not 条件中 column 的等值或 IN 条件，
+// ok 为 false 表示该条件不能缩小路由的范围
+func conditionKeys(column string, not bool, query interface{}, args ...interface{}) (keys []interface{}, ok bool) {
+	if column == "" {
+		return nil, false
+	}
+
+	switch q := query.(type) {
+	case string:
+		return stringConditionKeys(column, not, q, args)
+	case map[string]interface{}:
+		// Not(map) 是不等条件
+		if not {
+			return nil, false
+		}
+
+		for k, v := range q {
+			if sameColumn(k, column) {
+				return expandValues(v), true
+			}
+		}
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(query)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if not || rv.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	if key, err := ShardKey(rv.Interface()); err == nil {
+		return []interface{}{key}, true
+	}
+
+	typ := rv.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" || !sameColumn(fieldColumn(f), column) || rv.Field(i).IsZero() {
+			continue
+		}
+		return []interface{}{rv.Field(i).Interface()}, true
+	}
+	return nil, false
+}
+
+func stringConditionKeys(column string, not bool, query string, args []interface{}) ([]interface{}, bool) {
+	query = trimParens(strings.TrimSpace(query))
+	if orRe.MatchString(query) {
+		return nil, false
+	}
+
+	conjuncts := andRe.Split(query, -1)
+	// NOT (a AND b) 不能缩小范围
+	if not && len(conjuncts) > 1 {
+		return nil, false
+	}
+
+	placeholder := 0
+	for _, c := range conjuncts {
+		c = trimParens(strings.TrimSpace(c))
+		idx := placeholder
+		placeholder += strings.Count(c, "?")
+
+		m := predicateRe.FindStringSubmatch(c)
+		if m == nil || !sameColumn(m[1], column) {
+			continue
+		}
+
+		op := strings.Join(strings.Fields(strings.ToLower(m[2])), " ")
+		in := op == "in" || op == "not in"
+		if not != (op == "!=" || op == "<>" || op == "not in") {
+			continue
+		}
+
+		rhs := strings.TrimSpace(m[3])
+		if in {
+			rhs = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(rhs, "("), ")"))
+		}
+
+		if rhs == "?" {
+			if idx >= len(args) {
+				return nil, false
+			}
+
+			if in {
+				return expandValues(args[idx]), true
+			}
+			return []interface{}{args[idx]}, true
+		}
+
+		if strings.Contains(rhs, "?") {
+			continue
+		}
+
+		var keys []interface{}
+		for _, lit := range strings.Split(rhs, ",") {
+			lit = strings.TrimSpace(lit)
+			if isIdentifier(lit) {
+				keys = nil
+				break
+			}
+			keys = append(keys, literalValue(lit))
+		}
+
+		// user_id = b.user_id 的值在执行时才确定，不能用来路由
+		if len(keys) == 0 {
+			continue
+		}
+
+		if !in && len(keys) != 1 {
+			continue
+		}
+		return keys, true
+	}
+	return nil, false
+}
+
+// isIdentifier 列名，不是占位符、字面量或者 NULL、TRUE、FALSE
+func isIdentifier(s string) bool {
+	switch strings.ToLower(s) {
+	case "null", "true", "false":
+		return false
+	}
+	return identRe.MatchString(s)
+}
+
+// trimParens 去掉包住整个条件的括号
+func trimParens(s string) string {
+	for strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		depth := 0
+		for i, c := range s {
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				depth--
+			}
+
+			if depth == 0 && i < len(s)-1 {
+				return s
+			}
+		}
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+func literalValue(lit string) interface{} {
+	if len(lit) >= 2 && (lit[0] == '\'' || lit[0] == '"') && lit[len(lit)-1] == lit[0] {
+		return lit[1 : len(lit)-1]
+	}
+
+	if v, err := strconv.ParseInt(lit, 10, 64); err == nil {
+		return v
+	}
+	return lit
+}
+
+// int64Keys 用 int64Key 统一条件中的 sharding 值，占位符传入的 42 和字面量 42 路由到同一个库
+func int64Keys(keys []interface{}) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = int64Key(key)
+	}
+	return values
+}
+
+// expandValues 把 IN 的参数展开成多个值，[]byte 作为一个值
+func expandValues(value interface{}) []interface{} {
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return []interface{}{value}
+	}
+
+	values := make([]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		values = append(values, rv.Index(i).Interface())
+	}
+	return values
+}
+
+func sameColumn(a, b string) bool {
+	a = strings.Trim(a[strings.LastIndex(a, ".")+1:], "`\"")
+	return strings.EqualFold(a, b)
+}
+
+// fieldColumn 与 gorm 一致，优先使用 `gorm:"column:xxx"`
+func fieldColumn(f reflect.StructField) string {
+	for _, opt := range strings.Split(f.Tag.Get("gorm"), ";") {
+		kv := strings.SplitN(opt, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "column") {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return toColumnName(f.Name)
+}
+
+// toColumnName UserID -> user_id
+func toColumnName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
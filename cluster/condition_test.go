@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type conditionUser struct {
+	ID     int64
+	UserID int64
+	Name   string
+}
+
+type taggedUser struct {
+	ID    int64
+	Owner int64 `gorm:"column:user_id"`
+}
+
+func TestConditionKeys(t *testing.T) {
+	cases := []struct {
+		not   bool
+		query interface{}
+		args  []interface{}
+		keys  []interface{}
+		ok    bool
+	}{
+		{false, "user_id = ?", []interface{}{7}, []interface{}{7}, true},
+		{false, "`users`.`user_id`=?", []interface{}{7}, []interface{}{7}, true},
+		{false, "name = ? AND user_id IN (?)", []interface{}{"a", []int64{1, 2}}, []interface{}{int64(1), int64(2)}, true},
+		{false, "(user_id in (1, 2, '3'))", nil, []interface{}{int64(1), int64(2), "3"}, true},
+		{false, "user_id = 5", nil, []interface{}{int64(5)}, true},
+		{false, "user_id = b.user_id", nil, nil, false},
+		{false, "`a`.`user_id` = `b`.`user_id` AND name = ?", []interface{}{"a"}, nil, false},
+		{false, "user_id IN (b.user_id, 1)", nil, nil, false},
+		{false, "user_id = b.user_id AND user_id = ?", []interface{}{3}, []interface{}{3}, true},
+		{false, "user_id = ? OR name = ?", []interface{}{1, "a"}, nil, false},
+		{false, "user_id > ?", []interface{}{1}, nil, false},
+		{false, "user_id = ?", nil, nil, false},
+		{false, "uid = ?", []interface{}{1}, nil, false},
+		{false, map[string]interface{}{"user_id": []int{3, 4}}, nil, []interface{}{3, 4}, true},
+		{false, &conditionUser{UserID: 9}, nil, []interface{}{int64(9)}, true},
+		{false, conditionUser{Name: "a"}, nil, nil, false},
+		{false, taggedUser{Owner: 11}, nil, []interface{}{int64(11)}, true},
+		{true, "user_id <> ?", []interface{}{1}, []interface{}{1}, true},
+		{true, "user_id NOT IN (?)", []interface{}{[]int{1, 2}}, []interface{}{1, 2}, true},
+		{true, "user_id = ?", []interface{}{1}, nil, false},
+		{true, "user_id != ? AND name = ?", []interface{}{1, "a"}, nil, false},
+		{true, map[string]interface{}{"user_id": 1}, nil, nil, false},
+	}
+
+	for _, c := range cases {
+		keys, ok := conditionKeys("user_id", c.not, c.query, c.args...)
+		if ok != c.ok || !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("not=%v %#v %v got %v %v, want %v %v", c.not, c.query, c.args, keys, ok, c.keys, c.ok)
+		}
+	}
+}
+
+func TestToColumnName(t *testing.T) {
+	for name, want := range map[string]string{
+		"UserID":    "user_id",
+		"ID":        "id",
+		"CreatedAt": "created_at",
+		"HTTPCode":  "http_code",
+		"Shard2Key": "shard2_key",
+	} {
+		if got := toColumnName(name); got != want {
+			t.Errorf("%v got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestTrimParens(t *testing.T) {
+	for in, want := range map[string]string{
+		"((a = 1))":         "a = 1",
+		"(a = 1) AND (b=2)": "(a = 1) AND (b=2)",
+		"a IN (1, 2)":       "a IN (1, 2)",
+	} {
+		if got := trimParens(in); got != want {
+			t.Errorf("%q got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWhereColumnCompareBroadcasts(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2, WithShardColumn("user_id"))
+
+	var users []tagUser
+	if err := c.Where("user_id = b.user_id").Find(&users); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range servers {
+		if len(s.statements("b.user_id")) != 1 {
+			t.Errorf("db %d not queried", i)
+		}
+	}
+}
+
+func TestWherePlaceholderRoutes(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2, WithShardColumn("user_id"))
+
+	var users []tagUser
+	if err := c.Where("user_id = ?", 42).Find(&users); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Where("user_id = 42").Find(&users); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Where("user_id IN (?)", []uint32{42}).Find(&users); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(servers[0].statements("user_id")) != 3 || len(servers[1].statements("user_id")) != 0 {
+		t.Fatal("placeholder and literal keys routed differently")
+	}
+}
+
+func TestWhereNoTableName(t *testing.T) {
+	c, _ := newFakeCluster(t, t.Name(), 2, WithShardColumn("user_id"))
+
+	var users []conditionUser
+	if err := c.Where("user_id = ?", 42).Find(&users); !errors.Is(err, ErrNoTableName) {
+		t.Fatalf("Find error = %v, want ErrNoTableName", err)
+	}
+}
@@ -1,6 +1,9 @@
 package cluster
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type GormClusterConfig struct {
 	DBNum      int
@@ -18,6 +21,15 @@ type GormClusterConfig struct {
 	ConnMaxLifeTime int64
 	Slaves          []*DB
 	Sharding        []*GormClusterConfig
+
+	// Ranges 不为空时按范围分库分表
+	Ranges []*RangeRule
+
+	// ShardColumn Cluster.Where 等从查询条件中解析该列的值来路由
+	ShardColumn string
+
+	// MaxLag 复制延迟超过该时间的 slave 不参与读
+	MaxLag time.Duration
 }
 
 func (o *GormClusterConfig) Master(dbIdx int) *DB {
@@ -54,30 +66,49 @@ func (o *GormClusterConfig) SlavesDB(dbIdx int) (ss []*DB) {
 			MaxOpenConns:    slave.MaxOpenConns,
 			MaxIdleConns:    slave.MaxIdleConns,
 			ConnMaxLifeTime: slave.ConnMaxLifeTime,
+			Weight:          slave.Weight,
 		})
 	}
 	return
 }
 
-func (o *GormClusterConfig) ShardingDB(dbIdx int) *Sharding {
+func (o *GormClusterConfig) ShardingDB(dbIdx int) (*Sharding, error) {
+	var tableSelector NodeOption = func(*NodeOptions) {}
+	if len(o.Ranges) > 0 {
+		selector, err := NewRangeSharding(o.Ranges...)
+		if err != nil {
+			return nil, err
+		}
+		tableSelector = WithTableSelector(selector)
+	}
+
 	var slaves []*ClusterNode
 	for _, s := range o.SlavesDB(dbIdx) {
-		slaves = append(slaves, NewClusterNode(
+		slave, err := NewClusterNode(
 			WithTableNum(o.TableNum),
 			WithDB(s),
 			WithDBIndex(dbIdx),
-			WithIdentity("slave")),
-		)
+			WithIdentity("slave"),
+			tableSelector)
+		if err != nil {
+			return nil, err
+		}
+		slaves = append(slaves, slave)
 	}
 
-	master := NewClusterNode(
+	master, err := NewClusterNode(
 		WithTableNum(o.TableNum),
 		WithDB(o.Master(dbIdx)),
 		WithDBIndex(dbIdx),
-		WithIdentity("master"))
+		WithIdentity("master"),
+		tableSelector)
+	if err != nil {
+		return nil, err
+	}
 
 	return NewSharding(
 		WithMaster(master),
 		WithSlaves(slaves),
+		WithMaxLag(o.MaxLag),
 	)
 }
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/go-gorm/gorm"
+)
+
+// connCommon 把 *sql.Conn 适配成 gorm.SQLCommon，gorm 的所有语句都在同一个连接上执行。
+// 它没有 Begin 方法，gorm 的 create/update/delete 不会再开启本地事务
+type connCommon struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (c *connCommon) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c *connCommon) Prepare(query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(c.ctx, query)
+}
+
+func (c *connCommon) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c *connCommon) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+// openConn 从 node 的连接池中取出一个连接，返回绑定在该连接上的 gorm.DB
+func (n *ClusterNode) openConn(ctx context.Context) (*gorm.DB, *sql.Conn, error) {
+	if n.db == nil {
+		return nil, nil, ErrNotOpen
+	}
+
+	conn, err := n.db.DB().Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := gorm.Open(n.opts.db.Driver, &connCommon{ctx: ctx, conn: conn})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return db, conn, nil
+}
+
+// closedDB 没有 Open 的节点上的 gorm.DB，所有语句都返回 ErrNotOpen
+func (n *ClusterNode) closedDB() *gorm.DB {
+	dialect := n.opts.db.Driver
+	if dialect == "" {
+		dialect = "mysql"
+	}
+
+	// ping 一定失败，db 仍然可以用来携带错误
+	db, _ := gorm.Open(dialect, sql.OpenDB(errConnector{err: ErrNotOpen}))
+	return db
+}
+
+// errRow 返回携带 err 的 *sql.Row，不会执行任何语句
+func errRow(err error) *sql.Row {
+	db := sql.OpenDB(errConnector{err: err})
+	defer db.Close()
+	return db.QueryRow("SELECT 1")
+}
+
+// errConnector 建立连接总是返回 err
+type errConnector struct {
+	err error
+}
+
+func (c errConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, c.err
+}
+
+func (c errConnector) Driver() driver.Driver {
+	return errDriver{err: c.err}
+}
+
+type errDriver struct {
+	err error
+}
+
+func (d errDriver) Open(string) (driver.Conn, error) {
+	return nil, d.err
+}
@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ConsistentHash 一致性hash DBSelector，增减库时只有少量key会迁移
+type ConsistentHash struct {
+	opt ConsistentHashOptions
+
+	mtx   sync.RWMutex
+	rings map[int]*hashRing
+}
+
+// ConsistentHashOptions ConsistentHash 的选项，包含 HashOptions
+type ConsistentHashOptions struct {
+	HashOptions
+	replicas int
+}
+
+// ConsistentHashOption 可以是 HashOption 或者 WithReplicas
+type ConsistentHashOption interface {
+	applyConsistentHash(*ConsistentHashOptions)
+}
+
+func (o HashOption) applyConsistentHash(opt *ConsistentHashOptions) {
+	o(&opt.HashOptions)
+}
+
+type replicasOption int
+
+func (n replicasOption) applyConsistentHash(opt *ConsistentHashOptions) {
+	opt.replicas = int(n)
+}
+
+// WithReplicas ConsistentHash 每个库在环上的虚拟节点数，HashSharding 没有虚拟节点，不接受该选项
+func WithReplicas(num int) ConsistentHashOption {
+	return replicasOption(num)
+}
+
+// NewConsistentHash 默认使用 CRC32，每个库 160 个虚拟节点
+func NewConsistentHash(opts ...ConsistentHashOption) *ConsistentHash {
+	opt := ConsistentHashOptions{
+		HashOptions: HashOptions{hash: CRC32},
+		replicas:    160,
+	}
+
+	for _, o := range opts {
+		o.applyConsistentHash(&opt)
+	}
+
+	if opt.replicas <= 0 {
+		opt.replicas = 1
+	}
+
+	return &ConsistentHash{
+		opt:   opt,
+		rings: make(map[int]*hashRing),
+	}
+}
+
+// Number 实现 DBSelector，Cluster 会优先使用 RouteDB，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteDB
+func (c *ConsistentHash) Number(num int, values ...interface{}) uint64 {
+	idx, err := c.RouteDB(num, values...)
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+func (c *ConsistentHash) RouteDB(num int, values ...interface{}) (uint64, error) {
+	if num == 1 {
+		return 0, nil
+	}
+
+	if len(values) != 1 {
+		return 0, fmt.Errorf("%w: consistent hash sharding db values len must be 1", ErrNoShardKey)
+	}
+
+	key, err := shardingKey(values[0])
+	if err != nil {
+		return 0, err
+	}
+
+	return c.ring(num).get(c.opt.hash(key)), nil
+}
+
+// Moved 返回库数量从 from 变为 to 时需要迁移的key，有 key 不能路由时返回错误
+func (c *ConsistentHash) Moved(from, to int, keys ...interface{}) (moved []interface{}, err error) {
+	for _, key := range keys {
+		before, err := c.RouteDB(from, key)
+		if err != nil {
+			return nil, err
+		}
+
+		after, err := c.RouteDB(to, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if before != after {
+			moved = append(moved, key)
+		}
+	}
+	return moved, nil
+}
+
+func (c *ConsistentHash) ring(num int) *hashRing {
+	c.mtx.RLock()
+	r, ok := c.rings[num]
+	c.mtx.RUnlock()
+	if ok {
+		return r
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if r, ok = c.rings[num]; ok {
+		return r
+	}
+
+	r = newHashRing(num, c.opt.replicas, c.opt.hash)
+	c.rings[num] = r
+	return r
+}
+
+type hashRing struct {
+	points []uint64
+	owners map[uint64]uint64
+}
+
+func newHashRing(num int, replicas int, hash HashFunc) *hashRing {
+	r := &hashRing{owners: make(map[uint64]uint64, num*replicas)}
+	for i := 0; i < num; i++ {
+		for j := 0; j < replicas; j++ {
+			point := hash([]byte(fmt.Sprintf("db-%d#%d", i, j)))
+			// 冲突时保留编号小的库，保证增加库时已有的点不变
+			if _, ok := r.owners[point]; ok {
+				continue
+			}
+			r.owners[point] = uint64(i)
+			r.points = append(r.points, point)
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+func (r *hashRing) get(h uint64) uint64 {
+	idx := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if idx == len(r.points) {
+		idx = 0
+	}
+	return r.owners[r.points[idx]]
+}
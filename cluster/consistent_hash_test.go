@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConsistentHashRouteDB(t *testing.T) {
+	c := NewConsistentHash()
+
+	for i := 0; i < 1000; i++ {
+		idx, err := c.RouteDB(4, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if idx >= 4 {
+			t.Fatalf("key %v routed to db %v of 4", i, idx)
+		}
+
+		again, _ := c.RouteDB(4, i)
+		if again != idx {
+			t.Fatalf("key %v routed to %v then %v", i, idx, again)
+		}
+	}
+
+	if idx, err := c.RouteDB(1, "any"); err != nil || idx != 0 {
+		t.Fatalf("single db got %v %v", idx, err)
+	}
+
+	if _, err := c.RouteDB(4); !errors.Is(err, ErrNoShardKey) {
+		t.Fatalf("no value got %v", err)
+	}
+
+	if _, err := c.RouteDB(4, 1.5); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("float value got %v", err)
+	}
+}
+
+func TestConsistentHashBalance(t *testing.T) {
+	c := NewConsistentHash()
+
+	counts := make([]int, 4)
+	for i := 0; i < 40000; i++ {
+		idx, _ := c.RouteDB(4, i)
+		counts[idx]++
+	}
+
+	for i, n := range counts {
+		if n < 7000 || n > 13000 {
+			t.Fatalf("db %v has %v of 40000 keys: %v", i, n, counts)
+		}
+	}
+}
+
+func TestConsistentHashMoved(t *testing.T) {
+	c := NewConsistentHash()
+
+	keys := make([]interface{}, 10000)
+	for i := range keys {
+		keys[i] = i
+	}
+
+	// 4 个库增加到 5 个时大约 1/5 的 key 迁移，且只会迁移到新库
+	moved, err := c.Moved(4, 5, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) < 1000 || len(moved) > 3000 {
+		t.Fatalf("moved %v of %v keys", len(moved), len(keys))
+	}
+
+	for _, key := range moved {
+		if idx, _ := c.RouteDB(5, key); idx != 4 {
+			t.Fatalf("key %v moved to old db %v", key, idx)
+		}
+	}
+}
+
+func TestConsistentHashOptions(t *testing.T) {
+	c := NewConsistentHash(WithHash(FNV1a), WithReplicas(3))
+	if c.opt.replicas != 3 || c.opt.hash == nil {
+		t.Fatalf("options not applied: replicas %v", c.opt.replicas)
+	}
+
+	c = NewConsistentHash(WithReplicas(0))
+	if c.opt.replicas != 1 {
+		t.Fatalf("replicas %v, want at least 1", c.opt.replicas)
+	}
+}
+
+func TestConsistentHashMovedError(t *testing.T) {
+	c := NewConsistentHash()
+	if _, err := c.Moved(4, 5, 1, 2.5); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("Moved error = %v, want ErrUnsupportedKeyType", err)
+	}
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/go-gorm/gorm"
+)
+
+// ctxCommon 把连接池适配成在 ctx 中执行的 gorm.SQLCommon，ctx 取消时中断执行中的语句。
+// gorm 开启的事务也在 ctx 中执行
+type ctxCommon struct {
+	ctx context.Context
+	db  *sql.DB
+}
+
+func (c *ctxCommon) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+func (c *ctxCommon) Prepare(query string) (*sql.Stmt, error) {
+	return c.db.PrepareContext(c.ctx, query)
+}
+
+func (c *ctxCommon) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c *ctxCommon) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *ctxCommon) Begin() (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, nil)
+}
+
+// BeginTx gorm 的 Begin 使用 context.Background 时改用 c.ctx
+func (c *ctxCommon) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if ctx.Done() == nil {
+		ctx = c.ctx
+	}
+	return c.db.BeginTx(ctx, opts)
+}
+
+// contextKey Set 到 gorm.DB 中的 ctx，callback 中可以通过 Get 获取
+const contextKey = "cluster:context"
+
+// contextDB 在 ctx 中执行的连接池上打开新的 gorm.DB，重新应用 n 的链式条件和 BlockGlobalUpdate。
+// 直接在 gorm.DB 上修改的 logger、callback 等设置不会保留。只能基于连接池创建，事务请使用 Sharding.Transaction
+func (n *ClusterNode) contextDB(ctx context.Context) *gorm.DB {
+	var pool *sql.DB
+	switch common := n.db.CommonDB().(type) {
+	case *sql.DB:
+		pool = common
+	case *ctxCommon:
+		pool = common.db
+	default:
+		db := n.db.New()
+		db.AddError(errors.New("with context needs a node on connection pool, not in a transaction"))
+		return db
+	}
+
+	base, err := gorm.Open(n.db.Dialect().GetName(), &ctxCommon{ctx: ctx, db: pool})
+	if err != nil {
+		db := n.db.New()
+		db.AddError(err)
+		return db
+	}
+	base.BlockGlobalUpdate(n.db.HasBlockGlobalUpdate())
+
+	db := base.Set(contextKey, ctx)
+	for _, fn := range n.scopes {
+		db = fn(db)
+	}
+	return db
+}
+
+// WithContext 返回在 ctx 中执行语句的节点，保留已有的条件
+func (n *ClusterNode) WithContext(ctx context.Context) *ClusterNode {
+	if n.db == nil {
+		return n.withError(ErrNotOpen)
+	}
+	return n.rebind(n.contextDB(ctx))
+}
+
+// WithContext 返回在 ctx 中执行所有读写的 Sharding
+func (n *Sharding) WithContext(ctx context.Context) *Sharding {
+	sh := n.derive()
+	sh.ctx = ctx
+	return sh
+}
+
+func (n *Sharding) context() context.Context {
+	if n.ctx == nil {
+		return context.Background()
+	}
+	return n.ctx
+}
+
+// WithContext 返回在 ctx 中执行的 Cluster，DB、Route、跨库查询、XA 事务等都使用该 ctx，
+// ctx 取消时所有库上执行中的语句都会中断
+func (c *Cluster) WithContext(ctx context.Context) *Cluster {
+	nc := *c
+	nc.ctx = ctx
+	return &nc
+}
+
+func (c *Cluster) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// sharding 返回第 idx 个库，携带 Cluster 的 ctx
+func (c *Cluster) sharding(idx int) *Sharding {
+	if c.ctx == nil {
+		return c.shardingList[idx]
+	}
+	return c.shardingList[idx].WithContext(c.ctx)
+}
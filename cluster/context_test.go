@@ -0,0 +1,87 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNodeWithContextKeepsConditions(t *testing.T) {
+	node, s := newFakeNode(t, "context/keep")
+
+	var users []tagUser
+	ctx := context.Background()
+	if err := node.Where("name = ?", "a").Set("k", "v").WithContext(ctx).Order("id").Find(&users).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	stmts := s.statements("SELECT")
+	if len(stmts) != 1 || !strings.Contains(stmts[0], "name = ?") || !strings.Contains(stmts[0], "ORDER BY `id`") {
+		t.Fatalf("statements = %q, want conditions kept", stmts)
+	}
+
+	if v, ok := node.Set("k", "v").WithContext(ctx).Get("k"); !ok || v != "v" {
+		t.Fatalf("Get = %v, %v, want setting kept", v, ok)
+	}
+}
+
+func TestNodeWithContextKeepsSettings(t *testing.T) {
+	node, s := newFakeNode(t, "context/settings")
+	node.db.BlockGlobalUpdate(true)
+
+	if err := node.WithContext(context.Background()).Delete(&tagUser{}).Error(); err == nil {
+		t.Fatal("Delete without conditions succeeded, want BlockGlobalUpdate kept")
+	}
+	if stmts := s.statements("DELETE"); len(stmts) != 0 {
+		t.Fatalf("statements = %q, want none", stmts)
+	}
+}
+
+func TestNodeWithContextCanceled(t *testing.T) {
+	node, _ := newFakeNode(t, "context/canceled")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var users []tagUser
+	err := node.WithContext(context.Background()).WithContext(ctx).Find(&users).Error()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Find error = %v, want context.Canceled", err)
+	}
+
+	// 原来的节点不受影响
+	if err := node.Find(&users).Error(); err != nil {
+		t.Fatalf("Find on node error = %v", err)
+	}
+}
+
+func TestNodeWithContextInTransaction(t *testing.T) {
+	node, _ := newFakeNode(t, "context/tx")
+
+	tx := node.Begin()
+	defer tx.Rollback()
+	if err := tx.WithContext(context.Background()).Error(); err == nil {
+		t.Fatal("WithContext in transaction succeeded")
+	}
+}
+
+// ctx 绑定在新打开的 gorm.DB 上，不修改原来节点的连接
+func TestNodeContextDB(t *testing.T) {
+	node, _ := newFakeNode(t, "context/db")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := node.contextDB(ctx)
+	if err := db.Error; err != nil {
+		t.Fatal(err)
+	}
+	common, ok := db.CommonDB().(*ctxCommon)
+	if !ok || common.ctx != ctx {
+		t.Fatalf("CommonDB = %T, want *ctxCommon with ctx", db.CommonDB())
+	}
+	if _, ok := node.db.CommonDB().(*sql.DB); !ok {
+		t.Fatalf("node CommonDB changed to %T", node.db.CommonDB())
+	}
+}
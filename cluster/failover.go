@@ -0,0 +1,264 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writableTimeout 检查候选 master 是否可写的超时
+const writableTimeout = 3 * time.Second
+
+// ErrMasterChanged 写入时 master 已经被切换，可以重新从 Cluster 获取 Sharding 后重试
+var ErrMasterChanged = errors.New("master changed")
+
+// FailoverEvent master 切换事件
+type FailoverEvent struct {
+	Sharding *Sharding
+	Old      *ClusterNode
+	New      *ClusterNode
+	Time     time.Time
+}
+
+// MasterDiscoverer 返回当前 master 的连接配置，current 为正在使用的配置
+type MasterDiscoverer interface {
+	Discover(current *DB) (*DB, error)
+}
+
+type MasterDiscovererFunc func(current *DB) (*DB, error)
+
+func (f MasterDiscovererFunc) Discover(current *DB) (*DB, error) {
+	return f(current)
+}
+
+// StaticMasters 依次检查候选的库，返回第一个可写 (@@read_only = 0) 的
+func StaticMasters(candidates ...*DB) MasterDiscovererFunc {
+	return func(current *DB) (*DB, error) {
+		for _, c := range candidates {
+			if writable(c) {
+				return c, nil
+			}
+		}
+		return nil, errors.New("no writable master in candidates")
+	}
+}
+
+// writable 用一个连接检查 db 是否可写，不修改 db，也不建立连接池
+func writable(db *DB) bool {
+	pool, err := sql.Open(db.driver(), db.dsn())
+	if err != nil {
+		return false
+	}
+	defer pool.Close()
+	pool.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), writableTimeout)
+	defer cancel()
+
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	var readOnly bool
+	err = conn.QueryRowContext(ctx, "SELECT @@GLOBAL.read_only").Scan(&readOnly)
+	return err == nil && !readOnly
+}
+
+// DNSMaster host 解析的地址变化时返回新地址的配置，需要 DB 用 Host 而不是 DataSource 配置
+func DNSMaster(host string) MasterDiscovererFunc {
+	var mtx sync.Mutex
+	var last string
+	return func(current *DB) (*DB, error) {
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no address for %v", host)
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		// 第一次解析的地址就是当前在用的地址
+		if last == "" || last == addrs[0] {
+			last = addrs[0]
+			return current, nil
+		}
+
+		last = addrs[0]
+		db := *current
+		db.Host = addrs[0]
+		db.DataSource = ""
+		return &db, nil
+	}
+}
+
+// WithFailoverHandler master 切换后调用 fn
+func WithFailoverHandler(fn func(e FailoverEvent)) ShardingOption {
+	return func(o *ShardingOptions) {
+		o.onFailover = fn
+	}
+}
+
+func (n *ClusterNode) retired() bool {
+	return n.state != nil && atomic.LoadInt32(&n.state.retired) == 1
+}
+
+// SetMaster 把 master 替换为已经 Open 的 node，之后通过旧 master 的写入返回 ErrMasterChanged
+func (n *Sharding) SetMaster(node *ClusterNode) error {
+	if node.db == nil {
+		return errors.New("new master is not open")
+	}
+
+	r := n.origin()
+	r.mtx.Lock()
+	old := r.master
+	if old == node {
+		r.mtx.Unlock()
+		return nil
+	}
+
+	// 没有 slave 时 master 也在 slaves 中
+	slaves := make([]*ClusterNode, len(r.slaves))
+	for i, s := range r.slaves {
+		if s == old {
+			s = node
+		}
+		slaves[i] = s
+	}
+	r.master, r.slaves = node, slaves
+	r.mtx.Unlock()
+
+	if old.state != nil {
+		atomic.StoreInt32(&old.state.retired, 1)
+	}
+
+	// Close 会等待执行中的查询结束，主库宕机时旧 master 可能从来没有 Open 成功
+	if old.db != nil {
+		go old.db.Close()
+	}
+
+	if r.opt.onFailover != nil {
+		r.opt.onFailover(FailoverEvent{Sharding: r, Old: old, New: node, Time: time.Now()})
+	}
+	return nil
+}
+
+type FailoverOptions struct {
+	interval time.Duration
+	onError  func(err error)
+}
+
+type FailoverOption func(*FailoverOptions)
+
+func WithFailoverInterval(interval time.Duration) FailoverOption {
+	return func(o *FailoverOptions) {
+		o.interval = interval
+	}
+}
+
+// WithFailoverErrorHandler 后台检查失败时调用 fn，比如发现 master 或者连接新的 master 失败
+func WithFailoverErrorHandler(fn func(err error)) FailoverOption {
+	return func(o *FailoverOptions) {
+		o.onError = fn
+	}
+}
+
+// FailoverWatcher 定时通过 MasterDiscoverer 发现 master，变化时切换
+type FailoverWatcher struct {
+	sharding   *Sharding
+	discoverer MasterDiscoverer
+	opt        FailoverOptions
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+// WatchMaster 在后台监视 master 的变化
+func (n *Sharding) WatchMaster(discoverer MasterDiscoverer, opts ...FailoverOption) *FailoverWatcher {
+	opt := FailoverOptions{
+		interval: 5 * time.Second,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	w := &FailoverWatcher{
+		sharding:   n.origin(),
+		discoverer: discoverer,
+		opt:        opt,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.opt.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.Check(); err != nil && w.opt.onError != nil {
+					w.opt.onError(err)
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+func (w *FailoverWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
+
+// Check 检查一次 master，发现新的 master 时连接并切换
+func (w *FailoverWatcher) Check() error {
+	cur := w.sharding.Master()
+	db, err := w.discoverer.Discover(cur.opts.db)
+	if err != nil {
+		return err
+	}
+
+	if db == cur.opts.db || sameDB(db, cur.opts.db) {
+		return nil
+	}
+
+	node, err := NewClusterNode(
+		WithTableNum(cur.opts.tableNum),
+		WithDB(db),
+		WithDBIndex(cur.opts.dbIndex),
+		WithIdentity(cur.opts.identity),
+		WithTableSelector(cur.opts.tableSelector))
+	if err != nil {
+		return err
+	}
+
+	if err := node.Open(); err != nil {
+		return err
+	}
+	return w.sharding.SetMaster(node)
+}
+
+func sameDB(a, b *DB) bool {
+	if a.DataSource != "" && b.DataSource != "" {
+		return a.DataSource == b.DataSource
+	}
+	return a.Host == b.Host && a.Port == b.Port && a.DBName == b.DBName
+}
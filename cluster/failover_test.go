@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readOnlyServer(dsn string, readOnly int64) *fakeServer {
+	s := newFakeServer(dsn)
+	s.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "read_only") {
+			return []string{"@@GLOBAL.read_only"}, [][]driver.Value{{readOnly}}
+		}
+		return nil, nil
+	})
+	return s
+}
+
+func TestStaticMasters(t *testing.T) {
+	readOnlyServer("failover/replica", 1)
+	readOnlyServer("failover/master", 0)
+
+	replica := &DB{Driver: "fakedb", DataSource: "failover/replica"}
+	master := &DB{Driver: "fakedb", DataSource: "failover/master"}
+	db, err := StaticMasters(replica, master)(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db != master {
+		t.Fatalf("Discover = %+v, want %+v", db, master)
+	}
+
+	// 检查不能修改候选的配置
+	want := DB{Driver: "fakedb", DataSource: "failover/master"}
+	if *master != want {
+		t.Fatalf("candidate changed to %+v", *master)
+	}
+
+	if _, err := StaticMasters(replica)(replica); err == nil {
+		t.Fatal("Discover succeeded without writable candidate")
+	}
+}
+
+func TestDSN(t *testing.T) {
+	db := &DB{UserName: "u", Password: "p", Host: "h", DBName: "d"}
+	if got, want := db.dsn(), "u:p@tcp(h:3306)/d?charset=utf8&parseTime=true&loc=Local"; got != want {
+		t.Fatalf("dsn = %v, want %v", got, want)
+	}
+	if db.Port != 0 || db.DataSource != "" || db.driver() != "mysql" {
+		t.Fatalf("dsn changed db to %+v", *db)
+	}
+}
+
+func TestSetMasterUnopened(t *testing.T) {
+	old, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name() + "/old"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh, err := NewSharding(WithMaster(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, s := newFakeNode(t, t.Name()+"/new")
+	if err := sh.SetMaster(node); err != nil {
+		t.Fatal(err)
+	}
+	if sh.Master() != node || !old.retired() {
+		t.Fatal("master was not replaced")
+	}
+
+	if err := sh.Exec("UPDATE users SET name = ?", "a").Error(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.statements("UPDATE users")) != 1 {
+		t.Fatal("write did not go to the new master")
+	}
+}
+
+func TestWatchMasterError(t *testing.T) {
+	sh, _, _ := newFakeSharding(t, t.Name(), 0)
+
+	discoverErr := errors.New("discover failed")
+	errs := make(chan error, 1)
+	w := sh.WatchMaster(MasterDiscovererFunc(func(current *DB) (*DB, error) {
+		return nil, discoverErr
+	}), WithFailoverInterval(time.Millisecond), WithFailoverErrorHandler(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}))
+	defer w.Stop()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, discoverErr) {
+			t.Fatalf("error = %v, want %v", err, discoverErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("check error not reported")
+	}
+}
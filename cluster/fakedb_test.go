@@ -0,0 +1,319 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-gorm/gorm"
+)
+
+// fakedb 测试用的 MySQL 替身，每个 DataSource 是一个独立的库，记录收到的语句，
+// 通过 hook 注入错误，通过 rows 返回查询结果
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+
+	// 使用 mysql 的方言生成 SQL，没有注册时使用通用方言
+	if d, ok := gorm.GetDialect("mysql"); ok {
+		gorm.RegisterDialect("fakedb", d)
+	} else if d, ok := gorm.GetDialect("common"); ok {
+		gorm.RegisterDialect("fakedb", d)
+	}
+}
+
+type fakeServer struct {
+	mtx   sync.Mutex
+	stmts []string
+	hook  func(query string) error
+	rows  func(query string, args []driver.Value) ([]string, [][]driver.Value)
+}
+
+var fakeServers = struct {
+	sync.Mutex
+	m map[string]*fakeServer
+}{m: make(map[string]*fakeServer)}
+
+// newFakeServer 创建 dsn 对应的库，已有时替换
+func newFakeServer(dsn string) *fakeServer {
+	fakeServers.Lock()
+	defer fakeServers.Unlock()
+
+	s := &fakeServer{}
+	fakeServers.m[dsn] = s
+	return s
+}
+
+func fakeServerOf(dsn string) *fakeServer {
+	fakeServers.Lock()
+	defer fakeServers.Unlock()
+
+	s, ok := fakeServers.m[dsn]
+	if !ok {
+		s = &fakeServer{}
+		fakeServers.m[dsn] = s
+	}
+	return s
+}
+
+func (s *fakeServer) setHook(hook func(query string) error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.hook = hook
+}
+
+func (s *fakeServer) setRows(rows func(query string, args []driver.Value) ([]string, [][]driver.Value)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.rows = rows
+}
+
+func (s *fakeServer) exec(ctx context.Context, query string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	s.stmts = append(s.stmts, query)
+	hook := s.hook
+	s.mtx.Unlock()
+
+	if hook != nil {
+		return hook(query)
+	}
+	return nil
+}
+
+func (s *fakeServer) result(query string, args []driver.Value) ([]string, [][]driver.Value) {
+	s.mtx.Lock()
+	rows := s.rows
+	s.mtx.Unlock()
+
+	if rows != nil {
+		if columns, values := rows(query, args); columns != nil {
+			return columns, values
+		}
+	}
+
+	if strings.Contains(strings.ToLower(query), "count(") {
+		return []string{"count"}, [][]driver.Value{{int64(0)}}
+	}
+	return nil, nil
+}
+
+// statements 返回包含 substr 的语句，PING 除外
+func (s *fakeServer) statements(substr string) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var stmts []string
+	for _, stmt := range s.stmts {
+		if stmt != "PING" && strings.Contains(stmt, substr) {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+func (s *fakeServer) reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stmts = nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	return &fakeConn{s: fakeServerOf(dsn)}, nil
+}
+
+type fakeConn struct {
+	s *fakeServer
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.s.exec(ctx, "BEGIN"); err != nil {
+		return nil, err
+	}
+	return &fakeTx{c: c}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	return c.s.exec(ctx, "PING")
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.s.exec(ctx, query); err != nil {
+		return nil, err
+	}
+	return fakeResult{}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.s.exec(ctx, query); err != nil {
+		return nil, err
+	}
+
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+
+	columns, rows := c.s.result(query, values)
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeTx struct {
+	c *fakeConn
+}
+
+func (tx *fakeTx) Commit() error {
+	return tx.c.s.exec(context.Background(), "COMMIT")
+}
+
+func (tx *fakeTx) Rollback() error {
+	return tx.c.s.exec(context.Background(), "ROLLBACK")
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, a := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: a}
+	}
+	return values
+}
+
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (fakeResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newFakeNode 创建连接到 fakedb 上 dsn 库的节点
+func newFakeNode(t *testing.T, dsn string, opts ...NodeOption) (*ClusterNode, *fakeServer) {
+	t.Helper()
+
+	s := newFakeServer(dsn)
+	node, err := NewClusterNode(append([]NodeOption{WithDB(&DB{Driver: "fakedb", DataSource: dsn})}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { node.db.Close() })
+	return node, s
+}
+
+// newFakeCluster 创建 dbNum 个只有 master 的库，第 i 个库的 DataSource 为 name/i
+func newFakeCluster(t *testing.T, name string, dbNum int, opts ...Option) (*Cluster, []*fakeServer) {
+	t.Helper()
+
+	var (
+		shardings []*Sharding
+		servers   []*fakeServer
+	)
+	for i := 0; i < dbNum; i++ {
+		node, s := newFakeNode(t, fmt.Sprintf("%v/%d", name, i), WithDBIndex(i))
+		sh, err := NewSharding(WithMaster(node))
+		if err != nil {
+			t.Fatal(err)
+		}
+		shardings = append(shardings, sh)
+		servers = append(servers, s)
+	}
+
+	c, err := NewCluster(append([]Option{WithDBNum(dbNum), WithShardings(shardings...)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, servers
+}
+
+// newFakeSharding 创建一个 master 和 slaves 个 slave 的库，DataSource 为 name/master、name/slave0...
+func newFakeSharding(t *testing.T, name string, slaves int, opts ...ShardingOption) (*Sharding, *fakeServer, []*fakeServer) {
+	t.Helper()
+
+	master, ms := newFakeNode(t, name+"/master")
+	var (
+		nodes   []*ClusterNode
+		servers []*fakeServer
+	)
+	for i := 0; i < slaves; i++ {
+		node, s := newFakeNode(t, fmt.Sprintf("%v/slave%d", name, i))
+		nodes = append(nodes, node)
+		servers = append(servers, s)
+	}
+
+	sh, err := NewSharding(append([]ShardingOption{WithMaster(master), WithSlaves(nodes)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sh, ms, servers
+}
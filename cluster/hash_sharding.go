@@ -0,0 +1,96 @@
+package cluster
+
+import "fmt"
+
+// HashSharding 对字符串、[]byte、整数和 fmt.Stringer 做 hash 分库分表，同时实现了 DBSelector 和 TableSelector。
+// 库和表使用同一个 hash，同一个 key 总是落在同一张表
+type HashSharding struct {
+	opt HashOptions
+}
+
+func NewHashSharding(opts ...HashOption) *HashSharding {
+	opt := HashOptions{
+		hash: CRC32,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &HashSharding{opt: opt}
+}
+
+// Sum 返回 value 的 hash
+func (h *HashSharding) Sum(value interface{}) (uint64, error) {
+	key, err := shardingKey(value)
+	if err != nil {
+		return 0, err
+	}
+	return h.opt.hash(key), nil
+}
+
+func (h *HashSharding) RouteDB(num int, values ...interface{}) (uint64, error) {
+	if num == 1 {
+		return 0, nil
+	}
+
+	if len(values) != 1 {
+		return 0, fmt.Errorf("%w: hash sharding db values len must be 1", ErrNoShardKey)
+	}
+
+	sum, err := h.Sum(values[0])
+	if err != nil {
+		return 0, err
+	}
+	return sum % uint64(num), nil
+}
+
+func (h *HashSharding) RouteTable(originName string, num uint64, index int, values ...interface{}) (string, error) {
+	if num == 1 {
+		return originName, nil
+	}
+
+	if len(values) != 1 {
+		return "", fmt.Errorf("%w: hash sharding table values len must be 1", ErrNoShardKey)
+	}
+
+	sum, err := h.Sum(values[0])
+	if err != nil {
+		return "", err
+	}
+
+	// 库已经用 sum 取模，表再用 sum 取模会和库的编号相关，先打散一次
+	return fmt.Sprintf("%v_%08d", originName, uint64(index)*num+mix64(sum)%num), nil
+}
+
+// Number 实现 DBSelector，Cluster 会优先使用 RouteDB，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteDB
+func (h *HashSharding) Number(num int, values ...interface{}) uint64 {
+	idx, err := h.RouteDB(num, values...)
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+// Table 实现 TableSelector，ClusterNode 会优先使用 RouteTable，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteTable
+func (h *HashSharding) Table(originName string, num uint64, index int, values ...interface{}) string {
+	name, err := h.RouteTable(originName, num, index, values...)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// mix64 murmur3 fmix64
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb3f9e82d4bd3
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHashVectors(t *testing.T) {
+	cases := []struct {
+		name string
+		hash HashFunc
+		in   string
+		want uint64
+	}{
+		{"crc32", CRC32, "hello", 0x3610a686},
+		{"fnv1a", FNV1a, "", 0xcbf29ce484222325},
+		{"fnv1a", FNV1a, "a", 0xaf63dc4c8601ec8c},
+		{"murmur3", Murmur3, "", 0},
+		{"murmur3", Murmur3, "a", 0x3c2569b2},
+		{"murmur3", Murmur3, "abc", 0xb3dd93fa},
+		{"murmur3", Murmur3, "hello", 0x248bfa47},
+		{"murmur3", Murmur3, "The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+		{"xxhash", XXHash, "", 0xef46db3751d8e999},
+		{"xxhash", XXHash, "a", 0xd24ec4f1a98c6e5b},
+		{"xxhash", XXHash, "abc", 0x44bc2cf5ad770999},
+		{"xxhash", XXHash, "hello", 0x26c7827d889f6da3},
+		{"xxhash", XXHash, "Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+		{"xxhash", XXHash, "The quick brown fox jumps over the lazy dog", 0x0b242d361fda71bc},
+	}
+
+	for _, c := range cases {
+		if got := c.hash([]byte(c.in)); got != c.want {
+			t.Errorf("%v(%q) = %#x, want %#x", c.name, c.in, got, c.want)
+		}
+	}
+}
+
+type userID string
+
+type orderID int32
+
+type shopID int
+
+func (id shopID) String() string {
+	return "shop-" + strings.Repeat("x", int(id))
+}
+
+func TestShardingKey(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		want  string
+	}{
+		{"u1", "u1"},
+		{[]byte("u1"), "u1"},
+		{userID("u1"), "u1"},
+		{int8(-3), "-3"},
+		{orderID(42), "42"},
+		{uint64(1) << 63, "9223372036854775808"},
+		{shopID(2), "shop-xx"},
+	}
+
+	for _, c := range cases {
+		key, err := shardingKey(c.value)
+		if err != nil {
+			t.Fatalf("%T: %v", c.value, err)
+		}
+
+		if string(key) != c.want {
+			t.Fatalf("%T %v got %q, want %q", c.value, c.value, key, c.want)
+		}
+	}
+
+	for _, v := range []interface{}{nil, 1.5, struct{}{}, []int{1}} {
+		if _, err := shardingKey(v); !errors.Is(err, ErrUnsupportedKeyType) {
+			t.Fatalf("%T got %v", v, err)
+		}
+	}
+}
+
+func TestHashShardingRoute(t *testing.T) {
+	h := NewHashSharding(WithHash(Murmur3))
+
+	for i := 0; i < 100; i++ {
+		key := strings.Repeat("k", i)
+		db, err := h.RouteDB(4, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		named, _ := h.RouteDB(4, userID(key))
+		if named != db {
+			t.Fatalf("%q routed to %v, userID to %v", key, db, named)
+		}
+
+		table, err := h.RouteTable("user", 8, int(db), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 每个库的表编号是 [db*8, db*8+8)
+		var idx uint64
+		if _, err := fmt.Sscanf(table, "user_%08d", &idx); err != nil || idx/8 != db {
+			t.Fatalf("%q routed to table %v of db %v", key, table, db)
+		}
+	}
+
+	if _, err := h.RouteDB(4, 1.5); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("float got %v", err)
+	}
+
+	if _, err := h.RouteDB(4); !errors.Is(err, ErrNoShardKey) {
+		t.Fatalf("no value got %v", err)
+	}
+
+	if _, err := h.RouteTable("users", 4, 0, "a", "b"); !errors.Is(err, ErrNoShardKey) {
+		t.Fatalf("two values got %v", err)
+	}
+}
+
+func TestConsistentHashWithHash(t *testing.T) {
+	c := NewConsistentHash(WithHash(func([]byte) uint64 { return 0 }), WithReplicas(1))
+
+	first, _ := c.RouteDB(4, "a")
+	for _, key := range []interface{}{"b", userID("c"), orderID(7)} {
+		if idx, _ := c.RouteDB(4, key); idx != first {
+			t.Fatalf("constant hash routed %v to %v and %v", key, idx, first)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// nodeState 节点在多次复制之间共享的运行状态
+type nodeState struct {
+	lag       int64
+	unhealthy int32
+	failures  int32
+	successes int32
+	// retired 已经不是 master
+	retired int32
+
+	stats   latencyStats
+	breaker breaker
+}
+
+// Healthy 健康检查失败次数超过阈值后返回 false，直到检查恢复
+func (n *ClusterNode) Healthy() bool {
+	return n.state == nil || atomic.LoadInt32(&n.state.unhealthy) == 0
+}
+
+// Ping 检查节点的连接
+func (n *ClusterNode) Ping() error {
+	return n.PingContext(context.Background())
+}
+
+func (n *ClusterNode) PingContext(ctx context.Context) error {
+	if n.db == nil {
+		return ErrNotOpen
+	}
+	return n.db.DB().PingContext(ctx)
+}
+
+type HealthCheckOptions struct {
+	interval  time.Duration
+	failures  int32
+	successes int32
+	lagProbe  LagProbe
+}
+
+type HealthCheckOption func(*HealthCheckOptions)
+
+func WithCheckInterval(interval time.Duration) HealthCheckOption {
+	return func(o *HealthCheckOptions) {
+		o.interval = interval
+	}
+}
+
+// WithFailureThreshold 连续失败多少次后剔除节点
+func WithFailureThreshold(n int) HealthCheckOption {
+	return func(o *HealthCheckOptions) {
+		o.failures = int32(n)
+	}
+}
+
+// WithRecoverThreshold 剔除的节点连续成功多少次后恢复
+func WithRecoverThreshold(n int) HealthCheckOption {
+	return func(o *HealthCheckOptions) {
+		o.successes = int32(n)
+	}
+}
+
+// HealthChecker 定时 ping 所有 slave，连续失败的节点不再被 Balancer 选中
+type HealthChecker struct {
+	opt HealthCheckOptions
+	// nodes 每轮检查时获取要检查的节点
+	nodes func() []*ClusterNode
+
+	started sync.Once
+	stopped sync.Once
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewHealthChecker(nodes []*ClusterNode, opts ...HealthCheckOption) *HealthChecker {
+	opt := HealthCheckOptions{
+		interval:  5 * time.Second,
+		failures:  3,
+		successes: 1,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &HealthChecker{
+		opt:   opt,
+		nodes: func() []*ClusterNode { return nodes },
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start 在后台开始检查
+func (h *HealthChecker) Start() {
+	h.start(context.Background())
+}
+
+// start ctx 结束时停止检查，只有第一次调用生效
+func (h *HealthChecker) start(ctx context.Context) {
+	h.started.Do(func() {
+		go h.run(ctx)
+	})
+}
+
+func (h *HealthChecker) run(ctx context.Context) {
+	defer close(h.done)
+	ticker := time.NewTicker(h.opt.interval)
+	defer ticker.Stop()
+
+	for {
+		// 每轮检查不超过检查间隔
+		check, cancel := context.WithTimeout(ctx, h.opt.interval)
+		h.CheckContext(check)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop 停止检查并等待执行中的检查结束，可以多次调用，没有 Start 时也可以调用
+func (h *HealthChecker) Stop() {
+	h.stopped.Do(func() {
+		close(h.stop)
+		// 还没有 Start 时之后也不会再 Start
+		h.started.Do(func() {
+			close(h.done)
+		})
+		<-h.done
+	})
+}
+
+// Check 并发检查一次所有节点
+func (h *HealthChecker) Check() {
+	h.CheckContext(context.Background())
+}
+
+func (h *HealthChecker) CheckContext(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, node := range h.nodes() {
+		wg.Add(1)
+		go func(node *ClusterNode) {
+			defer wg.Done()
+			err := node.PingContext(ctx)
+			h.report(node, err)
+			if err == nil && h.opt.lagProbe != nil {
+				h.checkLag(ctx, node)
+			}
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (h *HealthChecker) report(node *ClusterNode, err error) {
+	s := node.state
+	if s == nil {
+		return
+	}
+
+	if err != nil {
+		atomic.StoreInt32(&s.successes, 0)
+		if atomic.AddInt32(&s.failures, 1) >= h.opt.failures {
+			atomic.StoreInt32(&s.unhealthy, 1)
+		}
+		return
+	}
+
+	atomic.StoreInt32(&s.failures, 0)
+	if atomic.LoadInt32(&s.unhealthy) == 1 && atomic.AddInt32(&s.successes, 1) >= h.opt.successes {
+		atomic.StoreInt32(&s.successes, 0)
+		atomic.StoreInt32(&s.unhealthy, 0)
+	}
+}
+
+// StartHealthCheck 检查所有库的 slave，每轮检查时重新获取，master 切换后检查新的 slave。
+// Cluster 的 ctx 结束时停止
+func (c *Cluster) StartHealthCheck(opts ...HealthCheckOption) *HealthChecker {
+	h := NewHealthChecker(nil, opts...)
+	h.nodes = c.slaves
+	h.start(c.context())
+	return h
+}
+
+// slaves 所有库当前的 slave，不包括 master
+func (c *Cluster) slaves() []*ClusterNode {
+	var nodes []*ClusterNode
+	for _, sh := range c.shardingList {
+		master := sh.Master()
+		for _, s := range sh.replicas() {
+			if s != master {
+				nodes = append(nodes, s)
+			}
+		}
+	}
+	return nodes
+}
+
+// usable 节点是否可以读
+func (n *Sharding) usable(node *ClusterNode) bool {
+	return node.Healthy() && n.fresh(node) && node.breaker().ready()
+}
+
+// candidates 返回可以读的 slave，都不可用时返回 master
+func (n *Sharding) candidates() []*ClusterNode {
+	slaves := n.replicas()
+	nodes := slaves
+	for i, s := range slaves {
+		if n.usable(s) {
+			continue
+		}
+
+		// 有节点被剔除时才复制
+		nodes = append([]*ClusterNode(nil), slaves[:i]...)
+		for _, s := range slaves[i+1:] {
+			if n.usable(s) {
+				nodes = append(nodes, s)
+			}
+		}
+		break
+	}
+
+	if len(nodes) == 0 {
+		return []*ClusterNode{n.Master()}
+	}
+	return nodes
+}
@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// eventually 每 5ms 检查一次 cond，1s 内没有满足时失败
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+	}
+}
+
+// countPings ping s 时计数，down 不为 0 时返回错误
+func countPings(s *fakeServer, down *int32) *int64 {
+	var n int64
+	s.setHook(func(query string) error {
+		if query != "PING" {
+			return nil
+		}
+		atomic.AddInt64(&n, 1)
+		if down != nil && atomic.LoadInt32(down) != 0 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	return &n
+}
+
+func TestHealthCheckSkipsUnhealthy(t *testing.T) {
+	sh, _, servers := newFakeSharding(t, t.Name(), 2)
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down := int32(1)
+	countPings(servers[0], &down)
+	h := c.StartHealthCheck(WithCheckInterval(10*time.Millisecond), WithFailureThreshold(2))
+	defer h.Stop()
+
+	slave := sh.replicas()[0]
+	eventually(t, func() bool { return !slave.Healthy() }, "failing slave not marked unhealthy")
+
+	var users []tagUser
+	for i := 0; i < 20; i++ {
+		if err := c.DB(int64(1)).Find(&users).Error(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stmts := servers[0].statements("SELECT"); len(stmts) != 0 {
+		t.Fatalf("unhealthy slave got %q", stmts)
+	}
+
+	// 恢复后重新被选中
+	atomic.StoreInt32(&down, 0)
+	eventually(t, slave.Healthy, "recovered slave still unhealthy")
+	eventually(t, func() bool {
+		c.DB(int64(1)).Find(&users)
+		return len(servers[0].statements("SELECT")) > 0
+	}, "recovered slave not read")
+}
+
+func TestHealthCheckCurrentSlaves(t *testing.T) {
+	sh, _, servers := newFakeSharding(t, t.Name(), 2)
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	promoted, other := countPings(servers[0], nil), countPings(servers[1], nil)
+	h := c.StartHealthCheck(WithCheckInterval(5 * time.Millisecond))
+	defer h.Stop()
+	eventually(t, func() bool { return atomic.LoadInt64(promoted) > 0 }, "slave not checked")
+
+	// slave 提升为 master 后不再检查
+	if err := sh.SetMaster(sh.replicas()[0]); err != nil {
+		t.Fatal(err)
+	}
+	start := atomic.LoadInt64(other)
+	eventually(t, func() bool { return atomic.LoadInt64(other) > start+1 }, "slave not checked")
+
+	// 切换时执行中的一轮检查结束后计数
+	checked := atomic.LoadInt64(promoted)
+	n := atomic.LoadInt64(other)
+	eventually(t, func() bool { return atomic.LoadInt64(other) > n+2 }, "slave not checked")
+	if got := atomic.LoadInt64(promoted); got != checked {
+		t.Fatalf("master checked %v more times", got-checked)
+	}
+}
+
+func TestHealthCheckerStop(t *testing.T) {
+	node, _ := newFakeNode(t, t.Name())
+
+	h := NewHealthChecker([]*ClusterNode{node})
+	h.Stop()
+	h.Stop()
+
+	// Stop 之后 Start 不再检查
+	h.Start()
+	select {
+	case <-h.done:
+	default:
+		t.Fatal("stopped checker not done")
+	}
+
+	h = NewHealthChecker([]*ClusterNode{node}, WithCheckInterval(time.Millisecond))
+	h.Start()
+	h.Start()
+	h.Stop()
+	h.Stop()
+}
+
+func TestLagProbeNotOpen(t *testing.T) {
+	node, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, probe := range map[string]LagProbe{
+		"slave status": SlaveStatusLag(),
+		"heartbeat":    HeartbeatLag("heartbeat", "ts"),
+	} {
+		if _, err := probe.Lag(context.Background(), node); !errors.Is(err, ErrNotOpen) {
+			t.Fatalf("%v lag error = %v, want ErrNotOpen", name, err)
+		}
+	}
+}
+
+func TestHeartbeatLagIdentifiers(t *testing.T) {
+	node, s := newFakeNode(t, t.Name())
+
+	for _, probe := range []LagProbe{
+		HeartbeatLag("heartbeat; DROP TABLE users", "ts"),
+		HeartbeatLag("heartbeat", "ts) FROM users; --"),
+	} {
+		if _, err := probe.Lag(context.Background(), node); err == nil {
+			t.Error("invalid identifier accepted")
+		}
+	}
+	if stmts := s.statements("SELECT"); len(stmts) != 0 {
+		t.Fatalf("statements = %q, want none", stmts)
+	}
+}
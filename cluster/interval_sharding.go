@@ -0,0 +1,204 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMultipleTables sharding 值覆盖了多张表，只能读不能写
+	ErrMultipleTables = errors.New("sharding value matches multiple tables")
+	// ErrTooManyTables sharding 值覆盖的表超过了 WithMaxUnion 的上限
+	ErrTooManyTables = errors.New("sharding value matches too many tables")
+)
+
+type Interval int
+
+const (
+	Day Interval = iota
+	Week
+	Month
+	Year
+)
+
+// TimeRange [Begin, End) 作为 sharding 值时会查询范围覆盖的所有表
+type TimeRange struct {
+	Begin time.Time
+	End   time.Time
+}
+
+type IntervalOptions struct {
+	interval Interval
+	layout   string
+	location *time.Location
+	maxUnion int
+}
+
+type IntervalOption func(*IntervalOptions)
+
+func WithInterval(interval Interval) IntervalOption {
+	return func(o *IntervalOptions) {
+		o.interval = interval
+	}
+}
+
+// WithLayout 表名后缀的时间格式，Week 时格式化的是每周周一的日期
+func WithLayout(layout string) IntervalOption {
+	return func(o *IntervalOptions) {
+		o.layout = layout
+	}
+}
+
+func WithLocation(loc *time.Location) IntervalOption {
+	return func(o *IntervalOptions) {
+		o.location = loc
+	}
+}
+
+// WithMaxUnion TimeRange 最多覆盖多少张表，默认 31，超过时返回 ErrTooManyTables
+func WithMaxUnion(n int) IntervalOption {
+	return func(o *IntervalOptions) {
+		o.maxUnion = n
+	}
+}
+
+// IntervalSharding 按时间分表的 TableSelector，如 order_202610
+type IntervalSharding struct {
+	opt IntervalOptions
+}
+
+func NewIntervalSharding(opts ...IntervalOption) *IntervalSharding {
+	opt := IntervalOptions{
+		interval: Month,
+		location: time.Local,
+		maxUnion: 31,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	if opt.layout == "" {
+		switch opt.interval {
+		case Year:
+			opt.layout = "2006"
+		case Month:
+			opt.layout = "200601"
+		default:
+			opt.layout = "20060102"
+		}
+	}
+
+	return &IntervalSharding{opt: opt}
+}
+
+// RangeTables 返回 [begin, end) 覆盖的所有表，超过 WithMaxUnion 时返回 ErrTooManyTables
+func (s *IntervalSharding) RangeTables(originName string, begin, end time.Time) (tables []string, err error) {
+	for t := s.truncate(begin); t.Before(end); t = s.next(t) {
+		if s.opt.maxUnion > 0 && len(tables) == s.opt.maxUnion {
+			return nil, fmt.Errorf("%w: time range [%v, %v) covers more than %v tables",
+				ErrTooManyTables, begin, end, s.opt.maxUnion)
+		}
+		tables = append(tables, s.tableName(originName, t))
+	}
+	return tables, nil
+}
+
+// Tables 实现 TableLister，按时间分表的表是无限的，不能广播查询
+func (s *IntervalSharding) Tables(originName string, num uint64, index int) ([]string, error) {
+	return nil, fmt.Errorf("interval sharding can not list tables of %v", originName)
+}
+
+func (s *IntervalSharding) RouteTable(originName string, num uint64, index int, values ...interface{}) (string, error) {
+	if len(values) != 1 {
+		return "", fmt.Errorf("%w: interval sharding table values len must be 1", ErrNoShardKey)
+	}
+
+	switch v := values[0].(type) {
+	case time.Time:
+		return s.tableName(originName, s.truncate(v)), nil
+	case *time.Time:
+		return s.tableName(originName, s.truncate(*v)), nil
+	case TimeRange:
+		return s.unionTable(originName, v)
+	case *TimeRange:
+		return s.unionTable(originName, *v)
+	}
+	return "", fmt.Errorf("%w: interval sharding value must be time.Time or TimeRange, got %T", ErrUnsupportedKeyType, values[0])
+}
+
+// Table 实现 TableSelector，ClusterNode 会优先使用 RouteTable，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteTable
+func (s *IntervalSharding) Table(originName string, num uint64, index int, values ...interface{}) string {
+	name, err := s.RouteTable(originName, num, index, values...)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// unionTable 范围覆盖多张表时用 UNION ALL 子查询代替表名，子查询只能用于读
+func (s *IntervalSharding) unionTable(originName string, r TimeRange) (string, error) {
+	if !r.Begin.Before(r.End) {
+		return "", fmt.Errorf("interval sharding time range [%v, %v) is empty", r.Begin, r.End)
+	}
+
+	tables, err := s.RangeTables(originName, r.Begin, r.End)
+	if err != nil {
+		return "", err
+	}
+
+	if len(tables) == 1 {
+		return tables[0], nil
+	}
+
+	selects := make([]string, 0, len(tables))
+	for _, t := range tables {
+		selects = append(selects, "SELECT * FROM "+quote(t))
+	}
+	return fmt.Sprintf("(%s) AS %s", strings.Join(selects, " UNION ALL "), quote(originName)), nil
+}
+
+// derivedTable 表名是否是 unionTable 返回的子查询
+func derivedTable(name string) bool {
+	return strings.HasPrefix(name, "(")
+}
+
+func quote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (s *IntervalSharding) tableName(originName string, t time.Time) string {
+	return fmt.Sprintf("%v_%v", originName, t.Format(s.opt.layout))
+}
+
+func (s *IntervalSharding) truncate(t time.Time) time.Time {
+	t = t.In(s.opt.location)
+	y, m, d := t.Date()
+	switch s.opt.interval {
+	case Year:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, s.opt.location)
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, s.opt.location)
+	case Week:
+		// 以周一作为一周的开始
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, s.opt.location)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, s.opt.location)
+}
+
+func (s *IntervalSharding) next(t time.Time) time.Time {
+	switch s.opt.interval {
+	case Year:
+		return t.AddDate(1, 0, 0)
+	case Month:
+		return t.AddDate(0, 1, 0)
+	case Week:
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
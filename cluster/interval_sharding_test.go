@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIntervalShardingRouteTable(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 13, 0, 0, 0, time.UTC) }
+
+	cases := []struct {
+		interval Interval
+		value    interface{}
+		table    string
+	}{
+		{Day, day(2026, 10, 17), "order_20261017"},
+		{Week, day(2026, 10, 17), "order_20261012"},
+		{Week, day(2026, 10, 12), "order_20261012"},
+		{Month, day(2026, 10, 17), "order_202610"},
+		{Year, day(2026, 10, 17), "order_2026"},
+		{Month, &TimeRange{Begin: day(2026, 10, 1), End: day(2026, 10, 31)}, "order_202610"},
+	}
+
+	for _, c := range cases {
+		s := NewIntervalSharding(WithInterval(c.interval), WithLocation(time.UTC))
+		table, err := s.RouteTable("order", 1, 0, c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if table != c.table {
+			t.Fatalf("interval %v value %v got %v, want %v", c.interval, c.value, table, c.table)
+		}
+	}
+}
+
+func TestIntervalShardingUnion(t *testing.T) {
+	s := NewIntervalSharding(WithLocation(time.UTC))
+	r := TimeRange{
+		Begin: time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	table, err := s.RouteTable("order", 1, 0, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "(SELECT * FROM `order_202611` UNION ALL SELECT * FROM `order_202612`) AS `order`"
+	if table != want {
+		t.Fatalf("got %v, want %v", table, want)
+	}
+
+	if !derivedTable(table) || derivedTable("order_202611") {
+		t.Fatal("derived table not detected")
+	}
+
+	tables, err := s.RangeTables("order", r.Begin, r.End)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tables, []string{"order_202611", "order_202612"}) {
+		t.Fatalf("range tables %v", tables)
+	}
+}
+
+func TestIntervalShardingErrors(t *testing.T) {
+	s := NewIntervalSharding(WithInterval(Day), WithLocation(time.UTC), WithMaxUnion(7))
+	begin := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := s.RouteTable("order", 1, 0, TimeRange{Begin: begin, End: begin.AddDate(0, 0, 7)}); err != nil {
+		t.Fatalf("7 days got %v", err)
+	}
+
+	if _, err := s.RouteTable("order", 1, 0, TimeRange{Begin: begin, End: begin.AddDate(3, 0, 0)}); !errors.Is(err, ErrTooManyTables) {
+		t.Fatalf("3 years got %v", err)
+	}
+
+	if _, err := s.RangeTables("order", begin, begin.AddDate(0, 0, 8)); !errors.Is(err, ErrTooManyTables) || errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("RangeTables of 8 days got %v", err)
+	}
+
+	if _, err := s.RouteTable("order", 1, 0, TimeRange{Begin: begin, End: begin}); err == nil {
+		t.Fatal("empty range routed")
+	}
+
+	if _, err := s.RouteTable("order", 1, 0, 1); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("int got %v", err)
+	}
+
+	if _, err := s.RouteTable("order", 1, 0); !errors.Is(err, ErrNoShardKey) {
+		t.Fatalf("no value got %v", err)
+	}
+
+	if _, err := s.Tables("order", 1, 0); err == nil {
+		t.Fatal("interval sharding listed tables")
+	}
+}
+
+type intervalOrder struct {
+	ID      int64
+	Created time.Time
+}
+
+func (intervalOrder) TableName() string {
+	return "order"
+}
+
+func TestIntervalShardingUnionReadOnly(t *testing.T) {
+	node, s := newFakeNode(t, t.Name(), WithTableSelector(NewIntervalSharding(WithLocation(time.UTC))))
+	r := TimeRange{
+		Begin: time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var orders []intervalOrder
+	if err := node.Table(r).Where("id > ?", 1).Find(&orders).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.statements("UNION ALL")) != 1 {
+		t.Fatalf("union not queried: %v", s.statements(""))
+	}
+
+	if err := node.Table(r).Create(&intervalOrder{ID: 1}).Error(); !errors.Is(err, ErrMultipleTables) {
+		t.Fatalf("create got %v", err)
+	}
+
+	if err := node.Table(r).Delete(&intervalOrder{ID: 1}).Error(); !errors.Is(err, ErrMultipleTables) {
+		t.Fatalf("delete got %v", err)
+	}
+
+	if err := node.Table(r).Model(&intervalOrder{}).Update("id", 2).Error(); !errors.Is(err, ErrMultipleTables) {
+		t.Fatalf("update got %v", err)
+	}
+
+	if stmts := s.statements("INSERT"); len(stmts) > 0 {
+		t.Fatalf("wrote to union: %v", stmts)
+	}
+
+	var count int64
+	if err := node.Table(r).Model(&intervalOrder{}).Count(&count).Error(); err != nil {
+		t.Fatalf("count got %v", err)
+	}
+
+	if err := node.Table(r.Begin).Create(&intervalOrder{ID: 1}).Error(); err != nil {
+		t.Fatalf("create in one table got %v", err)
+	}
+}
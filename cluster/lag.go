@@ -0,0 +1,174 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrReplicationStopped = errors.New("replication stopped")
+
+// lagUnknown 还没有检查过延迟
+const lagUnknown = -1
+
+// LagProbe 检查 slave 的复制延迟
+type LagProbe interface {
+	Lag(ctx context.Context, node *ClusterNode) (time.Duration, error)
+}
+
+type LagProbeFunc func(ctx context.Context, node *ClusterNode) (time.Duration, error)
+
+func (f LagProbeFunc) Lag(ctx context.Context, node *ClusterNode) (time.Duration, error) {
+	return f(ctx, node)
+}
+
+// SlaveStatusLag 使用 SHOW SLAVE STATUS 的 Seconds_Behind_Master，精度为秒，不是 slave 时延迟为 0
+func SlaveStatusLag() LagProbeFunc {
+	return func(ctx context.Context, node *ClusterNode) (time.Duration, error) {
+		if node.db == nil {
+			return 0, ErrNotOpen
+		}
+
+		rows, err := node.db.DB().QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+
+		if !rows.Next() {
+			return 0, rows.Err()
+		}
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return 0, err
+		}
+
+		values := make([]sql.RawBytes, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+
+		for i, c := range columns {
+			if !strings.EqualFold(c, "Seconds_Behind_Master") {
+				continue
+			}
+
+			// 复制线程没有运行时为 NULL
+			if values[i] == nil {
+				return 0, ErrReplicationStopped
+			}
+
+			sec, err := strconv.ParseInt(string(values[i]), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(sec) * time.Second, nil
+		}
+		return 0, errors.New("Seconds_Behind_Master not found in slave status")
+	}
+}
+
+// HeartbeatLag 读取心跳表 column 列的最新时间计算延迟，需要 master 定时写入 NOW(6)，比如 pt-heartbeat。
+// table 和 column 拼接在 SQL 中，不是标识符时检查返回错误
+func HeartbeatLag(table, column string) LagProbeFunc {
+	var invalid error
+	if !identifierPattern.MatchString(table) {
+		invalid = fmt.Errorf("heartbeat: invalid table %q", table)
+	} else if err := checkColumn(column); err != nil {
+		invalid = fmt.Errorf("heartbeat: %w", err)
+	}
+
+	return func(ctx context.Context, node *ClusterNode) (time.Duration, error) {
+		if invalid != nil {
+			return 0, invalid
+		}
+
+		if node.db == nil {
+			return 0, ErrNotOpen
+		}
+
+		var us sql.NullInt64
+		err := node.db.DB().QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT TIMESTAMPDIFF(MICROSECOND, MAX(%s), NOW(6)) FROM %s", column, table)).Scan(&us)
+		if err != nil {
+			return 0, err
+		}
+
+		if !us.Valid {
+			return 0, fmt.Errorf("heartbeat table %v is empty", table)
+		}
+
+		if us.Int64 < 0 {
+			return 0, nil
+		}
+		return time.Duration(us.Int64) * time.Microsecond, nil
+	}
+}
+
+// Lag 返回最近一次检查的复制延迟，没有检查过或者检查失败时 ok 为 false
+func (n *ClusterNode) Lag() (lag time.Duration, ok bool) {
+	if n.state == nil {
+		return 0, false
+	}
+
+	lag = time.Duration(atomic.LoadInt64(&n.state.lag))
+	if lag == lagUnknown {
+		return 0, false
+	}
+	return lag, true
+}
+
+func (n *ClusterNode) setLag(lag time.Duration) {
+	if n.state != nil {
+		atomic.StoreInt64(&n.state.lag, int64(lag))
+	}
+}
+
+// WithLagProbe 健康检查时同时检查复制延迟
+func WithLagProbe(probe LagProbe) HealthCheckOption {
+	return func(o *HealthCheckOptions) {
+		o.lagProbe = probe
+	}
+}
+
+func (h *HealthChecker) checkLag(ctx context.Context, node *ClusterNode) {
+	lag, err := h.opt.lagProbe.Lag(ctx, node)
+	if err != nil {
+		node.setLag(lagUnknown)
+		return
+	}
+	node.setLag(lag)
+}
+
+// MaxStaleness 本次查询可以接受的最大复制延迟，覆盖 WithMaxLag 的设置
+func (n *Sharding) MaxStaleness(d time.Duration) *Sharding {
+	sh := n.derive()
+	sh.staleness = d
+	return sh
+}
+
+// fresh 延迟是否在允许的范围内，没有限制时不检查
+func (n *Sharding) fresh(node *ClusterNode) bool {
+	limit := n.opt.maxLag
+	if n.staleness > 0 {
+		limit = n.staleness
+	}
+
+	if limit <= 0 || node == n.Master() {
+		return true
+	}
+
+	lag, ok := node.Lag()
+	return ok && lag <= limit
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-gorm/gorm"
+)
+
+const (
+	// ewmaAlpha 每次查询在 EWMA 中的权重
+	ewmaAlpha = 0.1
+	// costHalfLife 没有查询的节点的 cost 每过这么久减半，被 PowerOfTwoChoices 冷落的节点最终会重新被选中
+	costHalfLife = 10 * time.Second
+)
+
+// latencyStats 查询耗时和错误率的 EWMA
+type latencyStats struct {
+	mtx     sync.Mutex
+	latency float64
+	errRate float64
+	samples int64
+	updated time.Time
+}
+
+// record 记录一次查询，查询前已经有错误时没有访问数据库，不记录
+func (n *ClusterNode) record(d time.Duration, err error) {
+	if n.state == nil || n.db.Error != nil {
+		return
+	}
+
+	failed := 0.0
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		failed = 1
+	}
+
+	n.state.breaker.record(err)
+
+	s := &n.state.stats
+	s.mtx.Lock()
+	if s.samples == 0 {
+		s.latency, s.errRate = float64(d), failed
+	} else {
+		s.latency += ewmaAlpha * (float64(d) - s.latency)
+		s.errRate += ewmaAlpha * (failed - s.errRate)
+	}
+	s.samples++
+	s.updated = time.Now()
+	s.mtx.Unlock()
+}
+
+func (n *ClusterNode) observe(start time.Time, db *gorm.DB) *gorm.DB {
+	db.Error = n.done(start, db.Error)
+	return db
+}
+
+// done 记录耗时和错误，在已经切换的 master 上执行失败时返回 ErrMasterChanged
+func (n *ClusterNode) done(start time.Time, err error) error {
+	n.record(time.Since(start), err)
+
+	// 切换 master 时在旧 master 上执行中的写入
+	if err != nil && n.retired() && !errors.Is(err, ErrMasterChanged) {
+		return fmt.Errorf("%w: %v", ErrMasterChanged, err)
+	}
+	return err
+}
+
+// Latency 查询耗时的 EWMA
+func (n *ClusterNode) Latency() time.Duration {
+	if n.state == nil {
+		return 0
+	}
+
+	n.state.stats.mtx.Lock()
+	defer n.state.stats.mtx.Unlock()
+	return time.Duration(n.state.stats.latency)
+}
+
+// ErrorRate 查询错误率的 EWMA，查不到记录不算错误
+func (n *ClusterNode) ErrorRate() float64 {
+	if n.state == nil {
+		return 0
+	}
+
+	n.state.stats.mtx.Lock()
+	defer n.state.stats.mtx.Unlock()
+	return n.state.stats.errRate
+}
+
+// cost 耗时按错误率放大，没有查询过的节点为 0，会优先被选中。
+// 按最后一次查询之后的时间衰减，慢或者出错被冷落的节点恢复后还能重新得到流量
+func (n *ClusterNode) cost() float64 {
+	if n.state == nil {
+		return 0
+	}
+
+	s := &n.state.stats
+	s.mtx.Lock()
+	latency, errRate, idle := s.latency, s.errRate, time.Since(s.updated)
+	s.mtx.Unlock()
+
+	if errRate > 0.99 {
+		errRate = 0.99
+	}
+
+	decay := math.Exp2(-float64(idle) / float64(costHalfLife))
+	return latency * decay / (1 - errRate*decay)
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// setStats 设置节点的 EWMA，updated 为 idle 之前
+func setStats(node *ClusterNode, latency time.Duration, errRate float64, idle time.Duration) {
+	s := &node.state.stats
+	s.latency, s.errRate, s.samples, s.updated = float64(latency), errRate, 1, time.Now().Add(-idle)
+}
+
+func TestCostDecay(t *testing.T) {
+	node, err := NewClusterNode(WithDB(&DB{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node.cost() != 0 {
+		t.Fatalf("unsampled cost %v", node.cost())
+	}
+
+	setStats(node, 100*time.Millisecond, 0, 0)
+	if c := node.cost(); math.Abs(c-float64(100*time.Millisecond)) > float64(time.Millisecond) {
+		t.Fatalf("fresh cost %v", time.Duration(c))
+	}
+
+	setStats(node, 100*time.Millisecond, 0, costHalfLife)
+	if c := node.cost(); math.Abs(c-float64(50*time.Millisecond)) > float64(time.Millisecond) {
+		t.Fatalf("cost after half life %v", time.Duration(c))
+	}
+
+	// 错误率也随时间衰减
+	setStats(node, 100*time.Millisecond, 0.99, 0)
+	fresh := node.cost()
+	setStats(node, 100*time.Millisecond, 0.99, 2*costHalfLife)
+	if idle := node.cost(); idle > fresh/50 {
+		t.Fatalf("failing node cost %v, idle %v", time.Duration(fresh), time.Duration(idle))
+	}
+}
+
+func TestPowerOfTwoChoicesProbesIdleNode(t *testing.T) {
+	fast := weightedNode(t, 1)
+	slow := weightedNode(t, 1)
+	nodes := []*ClusterNode{fast, slow}
+	p2c := PowerOfTwoChoices()
+
+	setStats(fast, 10*time.Millisecond, 0, 0)
+	setStats(slow, time.Second, 0.5, 0)
+	for i := 0; i < 10; i++ {
+		if p2c.Next(nodes) != fast {
+			t.Fatal("slow node picked")
+		}
+	}
+
+	// 冷落两分钟之后慢节点的 cost 衰减到低于快节点，重新得到流量
+	setStats(slow, time.Second, 0.5, 2*time.Minute)
+	if p2c.Next(nodes) != slow {
+		t.Fatal("idle node never probed")
+	}
+}
+
+func TestRowRecordsError(t *testing.T) {
+	node, s := newFakeNode(t, t.Name())
+	s.setHook(func(query string) error {
+		if query != "PING" {
+			return errors.New("connection reset")
+		}
+		return nil
+	})
+
+	var n int
+	if err := node.Raw("SELECT 1").Row().Scan(&n); err == nil {
+		t.Fatal("row error not reported")
+	}
+
+	if node.ErrorRate() != 1 {
+		t.Fatalf("error rate %v", node.ErrorRate())
+	}
+}
@@ -10,12 +10,25 @@ type NodeOptions struct {
 	tableNum      uint64
 	dbIndex       int
 	identity      string
+	// dbNum 所在 Cluster 的库数量，Sharding 创建节点时从 Sharding 复制，不在 Cluster 中时为 0
+	dbNum int
 }
 
 type TableSelector interface {
 	Table(originName string, num uint64, index int, values ...interface{}) string
 }
 
+// TableRouter 可以返回路由错误的 TableSelector，ClusterNode 会优先使用 RouteTable
+type TableRouter interface {
+	RouteTable(originName string, num uint64, index int, values ...interface{}) (string, error)
+}
+
+// TableLister 列出 index 库上 originName 的所有物理表，广播查询时使用，表无法列出时返回错误。
+// 没有实现该接口的 TableSelector 按默认的 %v_%08d 规则列出
+type TableLister interface {
+	Tables(originName string, num uint64, index int) ([]string, error)
+}
+
 type NodeOption func(*NodeOptions)
 
 func WithTableSelector(selecter TableSelector) NodeOption {
@@ -54,21 +67,48 @@ func (d TableSelectorFunc) Table(originName string, num uint64, index int, value
 	return d(originName, num, index, values...)
 }
 
-func tableSelector(originName string, num uint64, index int, values ...interface{}) string {
+// defaultTableSelector 默认按 int64 取模分表，ClusterNode 使用 RouteTable，Table 只为实现 TableSelector
+type defaultTableSelector struct{}
+
+func (defaultTableSelector) Table(originName string, num uint64, index int, values ...interface{}) string {
+	name, err := tableSelector(originName, num, index, values...)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
+func (defaultTableSelector) RouteTable(originName string, num uint64, index int, values ...interface{}) (string, error) {
+	return tableSelector(originName, num, index, values...)
+}
+
+func tableSelector(originName string, num uint64, index int, values ...interface{}) (string, error) {
 	if num == 1 {
-		return originName
+		return originName, nil
 	}
 
 	if len(values) != 1 {
-		panic("default table num must be 1")
+		return "", fmt.Errorf("%w: default sharding table values len must be 1", ErrNoShardKey)
 	}
 
 	value, ok := values[0].(int64)
 	if !ok {
-		panic("value must be int64")
+		return "", fmt.Errorf("%w: value must be int64, got %T", ErrUnsupportedKeyType, values[0])
 	}
 
-	return fmt.Sprintf("%v_%08d", originName, int64(index)*int64(num)+value%int64(num))
+	return fmt.Sprintf("%v_%08d", originName, int64(index)*int64(num)+value%int64(num)), nil
+}
+
+func defaultTables(originName string, num uint64, index int) []string {
+	if num == 1 {
+		return []string{originName}
+	}
+
+	tables := make([]string, 0, num)
+	for i := uint64(0); i < num; i++ {
+		tables = append(tables, fmt.Sprintf("%v_%08d", originName, uint64(index)*num+i))
+	}
+	return tables
 }
 
 type DB struct {
@@ -83,4 +123,33 @@ type DB struct {
 	MaxIdleConns    int
 	MaxOpenConns    int
 	ConnMaxLifeTime int64
+
+	// Weight WeightedRoundRobin 使用的权重，默认 1
+	Weight int
+}
+
+// driver 没有配置时使用 mysql
+func (db *DB) driver() string {
+	if db.Driver == "" {
+		return "mysql"
+	}
+	return db.Driver
+}
+
+// dsn 没有配置 DataSource 时用 Host 等字段生成
+func (db *DB) dsn() string {
+	if db.DataSource != "" {
+		return db.DataSource
+	}
+
+	port := db.Port
+	if port == 0 {
+		port = 3306
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=true&loc=Local",
+		db.UserName,
+		db.Password,
+		fmt.Sprintf("%v:%v", db.Host, port),
+		db.DBName,
+	)
 }
@@ -0,0 +1,217 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// pageToken 记录每张表上一页最后一条记录的 key，done 的表已经没有数据
+type pageToken struct {
+	Key       string                   `json:"k"`
+	Positions map[string]*pagePosition `json:"p"`
+}
+
+type pagePosition struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+func (p *pagePosition) done() bool {
+	return p.Type == "done"
+}
+
+func newPagePosition(v reflect.Value) (*pagePosition, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("page key is nil")
+		}
+		v = v.Elem()
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		return &pagePosition{Type: "time", Value: t.Format(time.RFC3339Nano)}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &pagePosition{Type: "int", Value: strconv.FormatInt(v.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &pagePosition{Type: "uint", Value: strconv.FormatUint(v.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return &pagePosition{Type: "float", Value: strconv.FormatFloat(v.Float(), 'g', -1, 64)}, nil
+	case reflect.String:
+		return &pagePosition{Type: "string", Value: v.String()}, nil
+	}
+	return nil, fmt.Errorf("unsupported page key type %v", v.Type())
+}
+
+func (p *pagePosition) value() (interface{}, error) {
+	switch p.Type {
+	case "time":
+		return time.Parse(time.RFC3339Nano, p.Value)
+	case "int":
+		return strconv.ParseInt(p.Value, 10, 64)
+	case "uint":
+		return strconv.ParseUint(p.Value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(p.Value, 64)
+	case "string":
+		return p.Value, nil
+	}
+	return nil, fmt.Errorf("%w: unknown position type %v", ErrInvalidPageToken, p.Type)
+}
+
+func decodePageToken(token string, key string) (*pageToken, error) {
+	pt := &pageToken{Key: key, Positions: make(map[string]*pagePosition)}
+	if token == "" {
+		return pt, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	if err := json.Unmarshal(b, pt); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	if pt.Key != key {
+		return nil, fmt.Errorf("%w: token key %v is not %v", ErrInvalidPageToken, pt.Key, key)
+	}
+
+	if pt.Positions == nil {
+		pt.Positions = make(map[string]*pagePosition)
+	}
+	return pt, nil
+}
+
+func (pt *pageToken) encode() (string, error) {
+	b, err := json.Marshal(pt)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Paginate 按 key 做游标分页，每张表只查询 key 大于(DESC 时小于)该表上一页位置的 size 条记录，
+// 归并后返回前 size 条。token 为空时从第一页开始，返回的 next 为空时表示没有下一页。
+// key 在每张表内必须唯一，如 "id" 或 "created_at DESC"
+func (q *Query) Paginate(out interface{}, key string, size int, token string) (next string, err error) {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("paginate needs pointer to slice, got %T", out)
+	}
+
+	if size <= 0 {
+		return "", fmt.Errorf("invalid page size %v", size)
+	}
+
+	sliceType := rv.Elem().Type()
+	keys, err := orderKeys(sliceType.Elem(), []interface{}{key})
+	if err != nil {
+		return "", err
+	}
+
+	if len(keys) != 1 {
+		return "", fmt.Errorf("paginate needs one key, got %v", key)
+	}
+
+	pt, err := decodePageToken(token, key)
+	if err != nil {
+		return "", err
+	}
+
+	targets, err := q.targets(q.modelOf(out))
+	if err != nil {
+		return "", err
+	}
+
+	// key 拼接在 SQL 中，只能是列名和 ASC/DESC
+	fields := strings.Fields(key)
+	column := fields[0]
+	if err := checkColumn(column); err != nil {
+		return "", fmt.Errorf("page key: %w", err)
+	}
+	if len(fields) == 2 && !strings.EqualFold(fields[1], "asc") && !strings.EqualFold(fields[1], "desc") {
+		return "", fmt.Errorf("page key: invalid order %q", fields[1])
+	}
+
+	op := ">"
+	if keys[0].desc {
+		op = "<"
+	}
+
+	var (
+		parts  = make([]reflect.Value, tableCount(targets))
+		labels = make([]string, len(parts))
+	)
+	ferr := q.fanout(targets, func(slot int, t *queryTarget, table string) error {
+		label := fmt.Sprintf("%d/%s", t.index, table)
+		pos := pt.Positions[label]
+		if pos != nil && pos.done() {
+			return nil
+		}
+
+		db := q.conditions(t.node, table).Order(key).Limit(size)
+		if pos != nil {
+			last, err := pos.value()
+			if err != nil {
+				return err
+			}
+			db = db.Where(fmt.Sprintf("%s %s ?", column, op), last)
+		}
+
+		part := reflect.New(sliceType)
+		if err := db.Find(part.Interface()).Error; err != nil {
+			return err
+		}
+
+		parts[slot], labels[slot] = part.Elem(), label
+		return nil
+	})
+
+	// 有表失败时不能确定下一页的位置
+	if ferr != nil {
+		return "", ferr
+	}
+
+	result, consumed := mergeSorted(sliceType, parts, keys, 0, size)
+	more := false
+	for i, part := range parts {
+		// 已经查完的表没有查询
+		if !part.IsValid() {
+			continue
+		}
+
+		switch {
+		case consumed[i] == part.Len() && part.Len() < size:
+			pt.Positions[labels[i]] = &pagePosition{Type: "done"}
+			continue
+		case consumed[i] == 0:
+			more = true
+			continue
+		}
+
+		more = true
+		pos, err := newPagePosition(reflect.Indirect(part.Index(consumed[i] - 1)).FieldByIndex(keys[0].index))
+		if err != nil {
+			return "", err
+		}
+		pt.Positions[labels[i]] = pos
+	}
+
+	rv.Elem().Set(result)
+	if !more {
+		return "", nil
+	}
+	return pt.encode()
+}
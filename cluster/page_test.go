@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPagePosition(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	n := int32(-7)
+	for _, v := range []interface{}{int64(42), uint64(1 << 63), 1.25, "a b", now, &n} {
+		pos, err := newPagePosition(reflect.ValueOf(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := pos.value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := reflect.Indirect(reflect.ValueOf(v)).Interface()
+		if want == n {
+			want = int64(n)
+		}
+		if got != want {
+			t.Errorf("%v round trip got %#v", v, got)
+		}
+	}
+
+	if _, err := newPagePosition(reflect.ValueOf((*int64)(nil))); err == nil {
+		t.Error("nil key accepted")
+	}
+
+	if _, err := newPagePosition(reflect.ValueOf([]int{1})); err == nil {
+		t.Error("slice key accepted")
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	pt := &pageToken{Key: "id", Positions: map[string]*pagePosition{
+		"0/page_00000000": {Type: "int", Value: "3"},
+		"1/page_00000001": {Type: "done"},
+	}}
+	token, err := pt.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decodePageToken(token, "id")
+	if err != nil || !reflect.DeepEqual(got, pt) {
+		t.Fatalf("decode %v %v", got, err)
+	}
+
+	empty, err := decodePageToken("", "id")
+	if err != nil || empty.Key != "id" || len(empty.Positions) != 0 {
+		t.Fatalf("empty token %v %v", empty, err)
+	}
+
+	for _, c := range []struct{ token, key string }{
+		{token, "id DESC"},
+		{"!!", "id"},
+		{"bm90IGpzb24", "id"},
+	} {
+		if _, err := decodePageToken(c.token, c.key); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("%q %v got %v", c.token, c.key, err)
+		}
+	}
+
+	if _, err := (&pagePosition{Type: "bad"}).value(); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("unknown type got %v", err)
+	}
+}
+
+type pageRow struct {
+	ID     int64
+	UserID int64
+}
+
+func (pageRow) TableName() string {
+	return "page"
+}
+
+func TestPaginate(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	limit := regexp.MustCompile(`LIMIT (\d+)`)
+	data := [][]int64{{1, 4, 5, 9}, {2, 3, 6}}
+	for i, s := range servers {
+		ids := data[i]
+		s.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			if !strings.HasPrefix(query, "SELECT") {
+				return nil, nil
+			}
+
+			last := int64(0)
+			if len(args) > 0 {
+				last = args[len(args)-1].(int64)
+			}
+			size, _ := strconv.Atoi(limit.FindStringSubmatch(query)[1])
+
+			var rows [][]driver.Value
+			for _, id := range ids {
+				if id > last && len(rows) < size {
+					rows = append(rows, []driver.Value{id, int64(i)})
+				}
+			}
+			return []string{"id", "user_id"}, rows
+		})
+	}
+
+	var (
+		pages [][]int64
+		token string
+	)
+	for i := 0; i < 10; i++ {
+		var rows []pageRow
+		next, err := c.Where("1 = 1").Paginate(&rows, "id", 3, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int64
+		for _, r := range rows {
+			ids = append(ids, r.ID)
+		}
+		pages = append(pages, ids)
+
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	want := [][]int64{{1, 2, 3}, {4, 5, 6}, {9}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Fatalf("pages %v, want %v", pages, want)
+	}
+
+	var rows []pageRow
+	if _, err := c.Where("1 = 1").Paginate(&rows, "user_id", 3, token); !errors.Is(err, ErrInvalidPageToken) {
+		t.Fatalf("token of other key got %v", err)
+	}
+}
+
+func TestPaginateKey(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	for _, key := range []string{"id; DROP TABLE users", "id, ", "id desc, ", "id LIMIT", "id DESC LIMIT 1"} {
+		var rows []pageRow
+		if _, err := c.Where("1 = 1").Paginate(&rows, key, 3, ""); err == nil {
+			t.Errorf("key %q accepted", key)
+		}
+	}
+	for i, s := range servers {
+		if stmts := s.statements("SELECT"); len(stmts) != 0 {
+			t.Errorf("db %d got %q", i, stmts)
+		}
+	}
+
+	for _, key := range []string{"id", "id asc", "id DESC"} {
+		var rows []pageRow
+		if _, err := c.Where("1 = 1").Paginate(&rows, key, 3, ""); err != nil {
+			t.Errorf("key %q: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/go-gorm/gorm"
+)
+
+// Query 集群级别的查询，从 Where/Not 条件中解析 sharding 列的值来选择库和表，
+// 解析不到时按 NoShardKeyPolicy 广播或者返回 ErrNoShardKey
+type Query struct {
+	cluster *Cluster
+	model   interface{}
+	scopes  []func(*gorm.DB) *gorm.DB
+
+	orders []interface{}
+	groups []string
+	limit  interface{}
+	offset interface{}
+
+	keys     []interface{}
+	narrowed bool
+	broad    bool
+}
+
+func (c *Cluster) query() *Query {
+	return &Query{cluster: c}
+}
+
+// Model specify the model you would like to run db operations
+func (c *Cluster) Model(value interface{}) *Query {
+	return c.query().Model(value)
+}
+
+// Where 解析条件中 sharding 列的等值或 IN 条件来路由
+func (c *Cluster) Where(query interface{}, args ...interface{}) *Query {
+	return c.query().Where(query, args...)
+}
+
+// Not 解析条件中 sharding 列的不等或 NOT IN 条件来路由
+func (c *Cluster) Not(query interface{}, args ...interface{}) *Query {
+	return c.query().Not(query, args...)
+}
+
+func (q *Query) clone() *Query {
+	nq := *q
+	nq.scopes = append([]func(*gorm.DB) *gorm.DB(nil), q.scopes...)
+	nq.orders = append([]interface{}(nil), q.orders...)
+	nq.groups = append([]string(nil), q.groups...)
+	nq.keys = append([]interface{}(nil), q.keys...)
+	return &nq
+}
+
+func (q *Query) scope(fn func(*gorm.DB) *gorm.DB) *Query {
+	nq := q.clone()
+	nq.scopes = append(nq.scopes, fn)
+	return nq
+}
+
+// narrow 多个 Where 之间是 AND，取 sharding 值的交集
+func (q *Query) narrow(keys []interface{}) {
+	if !q.narrowed {
+		q.keys, q.narrowed = keys, true
+		return
+	}
+
+	var both []interface{}
+	for _, k := range q.keys {
+		for _, nk := range keys {
+			if fmt.Sprint(k) == fmt.Sprint(nk) {
+				both = append(both, k)
+				break
+			}
+		}
+	}
+	q.keys = both
+}
+
+func (q *Query) Model(value interface{}) *Query {
+	nq := q.clone()
+	nq.model = value
+	return nq
+}
+
+// Where return a new relation, filter records with given conditions, accepts `map`, `struct` or `string` as conditions, refer http://jinzhu.github.io/gorm/crud.html#query
+func (q *Query) Where(query interface{}, args ...interface{}) *Query {
+	nq := q.scope(func(db *gorm.DB) *gorm.DB { return db.Where(query, args...) })
+	if keys, ok := conditionKeys(q.cluster.opt.shardColumn, false, query, args...); ok {
+		nq.narrow(int64Keys(keys))
+	}
+	return nq
+}
+
+// Not filter records that don't match current conditions, similar to `Where`
+func (q *Query) Not(query interface{}, args ...interface{}) *Query {
+	nq := q.scope(func(db *gorm.DB) *gorm.DB { return db.Not(query, args...) })
+	if keys, ok := conditionKeys(q.cluster.opt.shardColumn, true, query, args...); ok {
+		nq.narrow(int64Keys(keys))
+	}
+	return nq
+}
+
+// Or filter records that match before conditions or this one, similar to `Where`，Or 之后不再按条件路由
+func (q *Query) Or(query interface{}, args ...interface{}) *Query {
+	nq := q.scope(func(db *gorm.DB) *gorm.DB { return db.Or(query, args...) })
+	nq.broad = true
+	return nq
+}
+
+// Select specify fields that you want to retrieve from database when querying, by default, will select all fields;
+func (q *Query) Select(query interface{}, args ...interface{}) *Query {
+	return q.scope(func(db *gorm.DB) *gorm.DB { return db.Select(query, args...) })
+}
+
+// Order specify order when retrieve records from database
+func (q *Query) Order(value interface{}, reorder ...bool) *Query {
+	nq := q.clone()
+	if len(reorder) > 0 && reorder[0] {
+		nq.orders = nil
+	}
+	nq.orders = append(nq.orders, value)
+	return nq
+}
+
+// Limit specify the number of records to be retrieved
+func (q *Query) Limit(limit interface{}) *Query {
+	nq := q.clone()
+	nq.limit = limit
+	return nq
+}
+
+// Offset specify the number of records to skip before starting to return the records
+func (q *Query) Offset(offset interface{}) *Query {
+	nq := q.clone()
+	nq.offset = offset
+	return nq
+}
+
+// queryTarget 一个库上要查询的表
+type queryTarget struct {
+	index  int
+	node   *ClusterNode
+	tables []string
+}
+
+// targets 按条件中的 sharding 值选择库和表，没有 sharding 值时按策略广播
+func (q *Query) targets(model interface{}) ([]*queryTarget, error) {
+	tn, ok := model.(TableName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNoTableName, model)
+	}
+	name := tn.TableName()
+
+	routes := make(map[uint64][]interface{})
+	if q.narrowed && !q.broad {
+		for _, key := range q.keys {
+			idx, err := q.cluster.dbIndex(key)
+			if err != nil {
+				return nil, err
+			}
+			routes[idx] = append(routes[idx], key)
+		}
+	} else if q.cluster.opt.noKeyPolicy == Reject {
+		return nil, fmt.Errorf("%w: query on %v has no %v condition", ErrNoShardKey, name, q.cluster.opt.shardColumn)
+	} else {
+		for i := range q.cluster.shardingList {
+			routes[uint64(i)] = nil
+		}
+	}
+
+	var targets []*queryTarget
+	for i := range q.cluster.shardingList {
+		keys, ok := routes[uint64(i)]
+		if !ok {
+			continue
+		}
+
+		node := q.cluster.sharding(i).slave()
+		tables, err := q.tables(node, model, name, keys)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &queryTarget{index: i, node: node, tables: tables})
+	}
+	return targets, nil
+}
+
+func (q *Query) tables(node *ClusterNode, model interface{}, name string, keys []interface{}) ([]string, error) {
+	if keys == nil {
+		return node.tables(name)
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		sv := ShardingValue{value: model, shradingValues: []interface{}{key},
+			tableNum: node.opts.tableNum, dbIndex: node.opts.dbIndex, tableSelector: node.opts.tableSelector}
+		table, err := sv.RouteTableName()
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+// conditions 在 table 上应用查询条件，设置了 Model 时带上 Model 使 Count、Sum 等聚合也过滤软删除的记录
+func (q *Query) conditions(node *ClusterNode, table string) *gorm.DB {
+	// Table 会清空 Model，所以 Model 放在 Table 之后
+	db := node.db.Table(table)
+	if q.model != nil {
+		db = db.Model(q.model)
+	}
+	for _, fn := range q.scopes {
+		db = fn(db)
+	}
+	return db
+}
+
+// build 在 table 上应用查询条件和排序
+func (q *Query) build(node *ClusterNode, table string) *gorm.DB {
+	db := q.conditions(node, table)
+	for _, o := range q.orders {
+		db = db.Order(o)
+	}
+	return db
+}
+
+// window 只查一张表时直接使用 Limit/Offset
+func (q *Query) window(db *gorm.DB) *gorm.DB {
+	if q.limit != nil {
+		db = db.Limit(q.limit)
+	}
+
+	if q.offset != nil {
+		db = db.Offset(q.offset)
+	}
+	return db
+}
+
+func (q *Query) modelOf(out interface{}) interface{} {
+	if q.model != nil {
+		return q.model
+	}
+	return newElem(out)
+}
+
+// Find 并发在路由到的所有表上查询，按 Order 归并结果后再应用 Offset 和 Limit，
+// 每张表最多查询 offset+limit 条。部分表失败时返回其余表的结果和 ShardErrors
+func (q *Query) Find(out interface{}) error {
+	targets, err := q.targets(q.modelOf(out))
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 1 && len(targets[0].tables) == 1 {
+		return q.window(q.build(targets[0].node, targets[0].tables[0])).Find(out).Error
+	}
+
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("find on multiple tables needs pointer to slice, got %T", out)
+	}
+
+	sliceType := rv.Elem().Type()
+	keys, err := orderKeys(sliceType.Elem(), q.orders)
+	if err != nil {
+		return err
+	}
+
+	offset, err := intValue(q.offset, 0)
+	if err != nil {
+		return err
+	}
+
+	limit, err := intValue(q.limit, -1)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]reflect.Value, tableCount(targets))
+	ferr := q.fanout(targets, func(slot int, t *queryTarget, table string) error {
+		db := q.build(t.node, table)
+		if limit >= 0 {
+			db = db.Limit(offset + limit)
+		}
+
+		part := reflect.New(sliceType)
+		if err := db.Find(part.Interface()).Error; err != nil {
+			return err
+		}
+
+		parts[slot] = part.Elem()
+		return nil
+	})
+
+	result, _ := mergeSorted(sliceType, parts, keys, offset, limit)
+	rv.Elem().Set(result)
+	return ferr
+}
+
+// First 依次在路由到的表上查询，返回第一条找到的记录
+func (q *Query) First(out interface{}) error {
+	targets, err := q.targets(q.modelOf(out))
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		for _, table := range t.tables {
+			err := q.build(t.node, table).First(out).Error
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+var ErrShardOutOfRange = errors.New("sharding value out of range")
+
+// RangeRule [Begin, End) 范围内的值落在 DBIndex 库的 [TableBegin, TableEnd] 表中，
+// 范围内的值按取模分布到各个表
+type RangeRule struct {
+	Begin      int64
+	End        int64
+	DBIndex    int
+	TableBegin int
+	TableEnd   int
+}
+
+// RangeSharding 按范围分库分表，同时实现了 DBSelector 和 TableSelector
+type RangeSharding struct {
+	rules []*RangeRule
+}
+
+func NewRangeSharding(rules ...*RangeRule) (*RangeSharding, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%w: range sharding must has rule", ErrInvalidConfig)
+	}
+
+	sorted := make([]*RangeRule, len(rules))
+	copy(sorted, rules)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Begin < sorted[j].Begin })
+
+	for i, r := range sorted {
+		if r.Begin >= r.End {
+			return nil, fmt.Errorf("%w: range sharding rule [%v, %v) is empty", ErrInvalidConfig, r.Begin, r.End)
+		}
+
+		if r.TableBegin > r.TableEnd {
+			return nil, fmt.Errorf("%w: range sharding rule [%v, %v) table %v > %v",
+				ErrInvalidConfig, r.Begin, r.End, r.TableBegin, r.TableEnd)
+		}
+
+		if i > 0 && sorted[i-1].End > r.Begin {
+			return nil, fmt.Errorf("%w: range sharding rule [%v, %v) overlaps [%v, %v)",
+				ErrInvalidConfig, sorted[i-1].Begin, sorted[i-1].End, r.Begin, r.End)
+		}
+	}
+
+	return &RangeSharding{rules: sorted}, nil
+}
+
+// Locate 返回 value 所在的范围
+func (r *RangeSharding) Locate(value interface{}) (*RangeRule, error) {
+	v, err := rangeValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := sort.Search(len(r.rules), func(i int) bool { return r.rules[i].End > v })
+	if idx == len(r.rules) || r.rules[idx].Begin > v {
+		return nil, fmt.Errorf("%w: %v", ErrShardOutOfRange, v)
+	}
+	return r.rules[idx], nil
+}
+
+func (r *RangeSharding) RouteDB(num int, values ...interface{}) (uint64, error) {
+	if len(values) != 1 {
+		return 0, fmt.Errorf("%w: range sharding db values len must be 1", ErrNoShardKey)
+	}
+
+	rule, err := r.Locate(values[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if rule.DBIndex >= num {
+		return 0, fmt.Errorf("%w: db index %v max:%v", ErrShardOutOfRange, rule.DBIndex, num)
+	}
+	return uint64(rule.DBIndex), nil
+}
+
+func (r *RangeSharding) RouteTable(originName string, num uint64, index int, values ...interface{}) (string, error) {
+	if len(values) != 1 {
+		return "", fmt.Errorf("%w: range sharding table values len must be 1", ErrNoShardKey)
+	}
+
+	rule, err := r.Locate(values[0])
+	if err != nil {
+		return "", err
+	}
+
+	if rule.DBIndex != index {
+		return "", fmt.Errorf("%w: value %v belongs to db %v not %v", ErrShardOutOfRange, values[0], rule.DBIndex, index)
+	}
+
+	// Begin 很小时 v-Begin 会超过 int64，v >= Begin，按 uint64 计算的差值是准确的
+	v, _ := rangeValue(values[0])
+	tables := uint64(rule.TableEnd - rule.TableBegin + 1)
+	return fmt.Sprintf("%v_%08d", originName, uint64(rule.TableBegin)+(uint64(v)-uint64(rule.Begin))%tables), nil
+}
+
+// Tables 实现 TableLister
+func (r *RangeSharding) Tables(originName string, num uint64, index int) (tables []string, err error) {
+	seen := make(map[int]bool)
+	for _, rule := range r.rules {
+		if rule.DBIndex != index {
+			continue
+		}
+
+		for i := rule.TableBegin; i <= rule.TableEnd; i++ {
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			tables = append(tables, fmt.Sprintf("%v_%08d", originName, i))
+		}
+	}
+	return
+}
+
+// Number 实现 DBSelector，Cluster 会优先使用 RouteDB，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteDB
+func (r *RangeSharding) Number(num int, values ...interface{}) uint64 {
+	idx, err := r.RouteDB(num, values...)
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+// Table 实现 TableSelector，ClusterNode 会优先使用 RouteTable，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteTable
+func (r *RangeSharding) Table(originName string, num uint64, index int, values ...interface{}) string {
+	name, err := r.RouteTable(originName, num, index, values...)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// rangeValue 按 Kind 转换，支持 type OrderID int64 这样的命名类型
+func rangeValue(value interface{}) (int64, error) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uintValue(rv.Uint())
+	}
+	return 0, fmt.Errorf("%w: range sharding value must be integer, got %T", ErrUnsupportedKeyType, value)
+}
+
+// uintValue 超过 int64 的值不在任何范围内
+func uintValue(v uint64) (int64, error) {
+	if v > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: %v overflows int64", ErrShardOutOfRange, v)
+	}
+	return int64(v), nil
+}
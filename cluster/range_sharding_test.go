@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type rangeOrderID int64
+
+func newTestRangeSharding(t *testing.T) *RangeSharding {
+	r, err := NewRangeSharding(
+		&RangeRule{Begin: 1000, End: 2000, DBIndex: 1, TableBegin: 2, TableEnd: 3},
+		&RangeRule{Begin: 0, End: 1000, DBIndex: 0, TableBegin: 0, TableEnd: 1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRangeShardingRoute(t *testing.T) {
+	r := newTestRangeSharding(t)
+
+	cases := []struct {
+		value interface{}
+		db    uint64
+		table string
+	}{
+		{0, 0, "orders_00000000"},
+		{int64(1), 0, "orders_00000001"},
+		{uint32(999), 0, "orders_00000001"},
+		{int16(1000), 1, "orders_00000002"},
+		{uint64(1001), 1, "orders_00000003"},
+		{uint(1999), 1, "orders_00000003"},
+		{rangeOrderID(1500), 1, "orders_00000002"},
+	}
+
+	for _, c := range cases {
+		db, err := r.RouteDB(2, c.value)
+		if err != nil {
+			t.Fatalf("%v: %v", c.value, err)
+		}
+
+		if db != c.db {
+			t.Fatalf("%v routed to db %v, want %v", c.value, db, c.db)
+		}
+
+		table, err := r.RouteTable("orders", 4, int(db), c.value)
+		if err != nil {
+			t.Fatalf("%v: %v", c.value, err)
+		}
+
+		if table != c.table {
+			t.Fatalf("%v routed to table %v, want %v", c.value, table, c.table)
+		}
+	}
+}
+
+func TestRangeShardingErrors(t *testing.T) {
+	r := newTestRangeSharding(t)
+
+	for _, v := range []interface{}{-1, 2000, uint64(math.MaxInt64) + 1, uint64(math.MaxUint64)} {
+		if _, err := r.RouteDB(2, v); !errors.Is(err, ErrShardOutOfRange) {
+			t.Fatalf("%v got %v", v, err)
+		}
+	}
+
+	if _, err := r.RouteDB(2, "1"); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("string got %v", err)
+	}
+
+	if _, err := r.RouteDB(1, 1500); !errors.Is(err, ErrShardOutOfRange) {
+		t.Fatalf("db index over num got %v", err)
+	}
+
+	if _, err := r.RouteTable("orders", 4, 0, 1500); !errors.Is(err, ErrShardOutOfRange) {
+		t.Fatalf("value of other db got %v", err)
+	}
+
+	if _, err := r.RouteDB(2, 1, 2); !errors.Is(err, ErrNoShardKey) {
+		t.Fatalf("two values got %v", err)
+	}
+
+	if _, err := r.RouteTable("orders", 4, 0); !errors.Is(err, ErrNoShardKey) {
+		t.Fatalf("no value got %v", err)
+	}
+}
+
+func TestRangeShardingWideRule(t *testing.T) {
+	r, err := NewRangeSharding(&RangeRule{Begin: math.MinInt64, End: math.MaxInt64, TableBegin: 0, TableEnd: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 - MinInt64 = 2^63 + 1，2^63 + 1 = 0 (mod 3)
+	for _, c := range []struct {
+		value int64
+		table string
+	}{
+		{math.MinInt64, "orders_00000000"},
+		{1, "orders_00000000"},
+		{2, "orders_00000001"},
+		{math.MaxInt64 - 1, "orders_00000002"},
+	} {
+		table, err := r.RouteTable("orders", 3, 0, c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table != c.table {
+			t.Fatalf("%v routed to %v, want %v", c.value, table, c.table)
+		}
+	}
+}
+
+func TestRangeShardingTables(t *testing.T) {
+	r := newTestRangeSharding(t)
+
+	want := []string{"orders_00000002", "orders_00000003"}
+	if got, _ := r.Tables("orders", 4, 1); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestNewRangeShardingInvalid(t *testing.T) {
+	cases := [][]*RangeRule{
+		nil,
+		{{Begin: 10, End: 10}},
+		{{Begin: 0, End: 10, TableBegin: 2, TableEnd: 1}},
+		{{Begin: 0, End: 10}, {Begin: 5, End: 20}},
+	}
+
+	for _, rules := range cases {
+		if _, err := NewRangeSharding(rules...); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("%v got %v", rules, err)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-gorm/gorm"
+)
+
+var ErrSagaAborted = errors.New("saga aborted")
+
+const (
+	sagaDone        = "done"
+	sagaCompensated = "compensated"
+	sagaCommitted   = "committed"
+	sagaAborting    = "aborting"
+	sagaAborted     = "aborted"
+)
+
+type SagaOptions struct {
+	table string
+	store *Sharding
+}
+
+type SagaOption func(*SagaOptions)
+
+// WithSagaTable 保存 saga 状态的表，默认 saga_states
+func WithSagaTable(table string) SagaOption {
+	return func(o *SagaOptions) {
+		o.table = table
+	}
+}
+
+// WithSagaStore saga 本身的状态所在的库，默认第一个库，每一步的状态保存在该步所在的库
+func WithSagaStore(store *Sharding) SagaOption {
+	return func(o *SagaOptions) {
+		o.store = store
+	}
+}
+
+type sagaStep struct {
+	name       string
+	values     []interface{}
+	action     func(tx *ShardTx) error
+	compensate func(tx *ShardTx) error
+}
+
+// Saga 跨库写入的补偿流程，每一步在 Cluster.DB(values...) 的事务中执行，失败时逆序执行已完成步骤的补偿。
+// action 和 compensate 只能通过 tx 写入，它们和该步的状态在同一个本地事务中提交，
+// 用同一个 id 重新 Run 时跳过已完成的步骤，继续未完成的补偿，不会重复执行已经提交的 action 或 compensate。
+// 状态表需要在每个库上创建，见 CreateSagaTable
+type Saga struct {
+	cluster *Cluster
+	opt     SagaOptions
+	id      string
+	steps   []*sagaStep
+}
+
+func (c *Cluster) Saga(id string, opts ...SagaOption) *Saga {
+	opt := SagaOptions{
+		table: "saga_states",
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	if opt.store == nil {
+		opt.store = c.shardingList[0]
+	}
+
+	return &Saga{cluster: c, opt: opt, id: id}
+}
+
+// CreateSagaTable 在 store 和每个库上创建 saga 状态表
+func (c *Cluster) CreateSagaTable(opts ...SagaOption) error {
+	s := c.Saga("", opts...)
+	masters := []*ClusterNode{s.opt.store.Master()}
+	for _, sh := range c.shardingList {
+		masters = appendNode(masters, sh.Master())
+	}
+
+	for _, m := range masters {
+		if m.db == nil {
+			return ErrNotOpen
+		}
+
+		err := m.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	saga_id VARCHAR(128) NOT NULL,
+	step VARCHAR(128) NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (saga_id, step)
+)`, s.opt.table)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendNode(nodes []*ClusterNode, node *ClusterNode) []*ClusterNode {
+	for _, n := range nodes {
+		if n == node {
+			return nodes
+		}
+	}
+	return append(nodes, node)
+}
+
+// Step 添加一步，name 在 saga 内唯一
+func (s *Saga) Step(name string, values []interface{}, action, compensate func(tx *ShardTx) error) *Saga {
+	s.steps = append(s.steps, &sagaStep{name: name, values: values, action: action, compensate: compensate})
+	return s
+}
+
+// Run 依次执行所有步骤，已经提交的 saga 直接返回 nil，已经回滚的返回 ErrSagaAborted
+func (s *Saga) Run() error {
+	for _, step := range s.steps {
+		if step.name == "" {
+			return errors.New("saga step name is empty")
+		}
+	}
+
+	states, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	switch states[""] {
+	case sagaCommitted:
+		return nil
+	case sagaAborted:
+		return ErrSagaAborted
+	case sagaAborting:
+		return s.abort(states, ErrSagaAborted)
+	}
+
+	for _, step := range s.steps {
+		if states[step.name] == sagaDone {
+			continue
+		}
+
+		err := s.exec(step, step.action, sagaDone)
+		if err == nil {
+			states[step.name] = sagaDone
+			continue
+		}
+
+		if serr := s.saveSaga(sagaAborting); serr != nil {
+			return fmt.Errorf("saga %v step %v: %v, save state: %w", s.id, step.name, err, serr)
+		}
+		return s.abort(states, fmt.Errorf("saga %v step %v: %w", s.id, step.name, err))
+	}
+	return s.saveSaga(sagaCommitted)
+}
+
+// abort 逆序补偿已完成的步骤，补偿失败时保持 aborting，下次 Run 继续补偿
+func (s *Saga) abort(states map[string]string, cause error) error {
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		if states[step.name] != sagaDone {
+			continue
+		}
+
+		if err := s.exec(step, step.compensate, sagaCompensated); err != nil {
+			return fmt.Errorf("saga %v compensate %v: %v, cause: %w", s.id, step.name, err, cause)
+		}
+		states[step.name] = sagaCompensated
+	}
+
+	if err := s.saveSaga(sagaAborted); err != nil {
+		return err
+	}
+	return cause
+}
+
+// exec 在该步所在库的 master 上开启事务，执行 fn 并保存该步的状态
+func (s *Saga) exec(step *sagaStep, fn func(tx *ShardTx) error, status string) error {
+	return s.cluster.DB(step.values...).Transaction(s.cluster.context(), func(tx *ShardTx) error {
+		if fn != nil {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return s.save(tx.db, step.name, status)
+	})
+}
+
+// load 从 store 读取 saga 的状态，从每一步所在的库读取该步的状态
+func (s *Saga) load() (map[string]string, error) {
+	masters := []*ClusterNode{s.opt.store.Master()}
+	for _, step := range s.steps {
+		sh := s.cluster.DB(step.values...)
+		if err := sh.Error(); err != nil {
+			return nil, err
+		}
+		masters = appendNode(masters, sh.Master())
+	}
+
+	states := make(map[string]string)
+	for i, m := range masters {
+		if err := s.loadFrom(m, i == 0, states); err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+func (s *Saga) loadFrom(node *ClusterNode, store bool, states map[string]string) error {
+	if node.db == nil {
+		return ErrNotOpen
+	}
+
+	rows, err := node.db.Raw(
+		fmt.Sprintf("SELECT step, status FROM %s WHERE saga_id = ?", s.opt.table), s.id).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var step, status string
+		if err := rows.Scan(&step, &status); err != nil {
+			return err
+		}
+
+		// store 和步骤的库不同时，saga 的状态只以 store 为准
+		if step != "" || store {
+			states[step] = status
+		}
+	}
+	return rows.Err()
+}
+
+// saveSaga 保存 saga 本身的状态，step 为空
+func (s *Saga) saveSaga(status string) error {
+	master := s.opt.store.Master()
+	if master.db == nil {
+		return ErrNotOpen
+	}
+	return s.save(master.db, "", status)
+}
+
+func (s *Saga) save(db *gorm.DB, step, status string) error {
+	return db.Exec(fmt.Sprintf(
+		"INSERT INTO %s (saga_id, step, status, updated_at) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE status = VALUES(status), updated_at = VALUES(updated_at)", s.opt.table),
+		s.id, step, status, time.Now()).Error
+}
@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// sagaStatements 返回事务和写入语句，忽略查询状态的 SELECT
+func sagaStatements(s *fakeServer) []string {
+	var stmts []string
+	for _, stmt := range s.statements("") {
+		f := strings.Fields(stmt)
+		switch f[0] {
+		case "SELECT":
+			continue
+		case "UPDATE", "INSERT":
+			f[0] += " " + f[1]
+		}
+		stmts = append(stmts, f[0])
+	}
+	return stmts
+}
+
+func sagaUpdate(table string) func(tx *ShardTx) error {
+	return func(tx *ShardTx) error {
+		return tx.Exec("UPDATE " + table + " SET a = 1").Error()
+	}
+}
+
+func TestSagaStepStateInActionTx(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	err := c.Saga("s1").
+		Step("debit", []interface{}{int64(0)}, sagaUpdate("account"), nil).
+		Step("credit", []interface{}{int64(1)}, sagaUpdate("account"), nil).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"BEGIN", "UPDATE account", "INSERT INTO", "COMMIT", "INSERT INTO"},
+		{"BEGIN", "UPDATE account", "INSERT INTO", "COMMIT"},
+	}
+	for i, s := range servers {
+		if got := sagaStatements(s); strings.Join(got, ",") != strings.Join(want[i], ",") {
+			t.Errorf("db %v: %v", i, got)
+		}
+	}
+}
+
+func TestSagaCompensate(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	servers[1].setHook(func(query string) error {
+		if strings.HasPrefix(query, "UPDATE") {
+			return errors.New("no balance")
+		}
+		return nil
+	})
+
+	err := c.Saga("s2").
+		Step("debit", []interface{}{int64(0)}, sagaUpdate("account"), sagaUpdate("refund")).
+		Step("credit", []interface{}{int64(1)}, sagaUpdate("account"), sagaUpdate("refund")).
+		Run()
+	if err == nil {
+		t.Fatal("failed step not reported")
+	}
+
+	want := [][]string{
+		{"BEGIN", "UPDATE account", "INSERT INTO", "COMMIT", "INSERT INTO", "BEGIN", "UPDATE refund", "INSERT INTO", "COMMIT", "INSERT INTO"},
+		{"BEGIN", "UPDATE account", "ROLLBACK"},
+	}
+	for i, s := range servers {
+		if got := sagaStatements(s); strings.Join(got, ",") != strings.Join(want[i], ",") {
+			t.Errorf("db %v: %v", i, got)
+		}
+	}
+}
+
+func TestSagaStateSaveFailure(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 1)
+	servers[0].setHook(func(query string) error {
+		if strings.Contains(query, "saga_states") && strings.Contains(query, "INSERT") {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+
+	if err := c.Saga("s3").Step("debit", []interface{}{int64(0)}, sagaUpdate("account"), nil).Run(); err == nil {
+		t.Fatal("state save failure not reported")
+	}
+
+	// 状态写入失败时 action 一起回滚
+	if got := sagaStatements(servers[0]); len(got) < 4 || got[3] != "ROLLBACK" || len(servers[0].statements("COMMIT")) != 0 {
+		t.Fatalf("statements %v", got)
+	}
+}
+
+func TestSagaResume(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	servers[0].setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "SELECT step, status") {
+			return []string{"step", "status"}, [][]driver.Value{{"debit", sagaDone}}
+		}
+		return nil, nil
+	})
+
+	err := c.Saga("s4").
+		Step("debit", []interface{}{int64(0)}, sagaUpdate("account"), nil).
+		Step("credit", []interface{}{int64(1)}, sagaUpdate("account"), nil).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(servers[0].statements("UPDATE account")); n != 0 {
+		t.Fatalf("done step executed again")
+	}
+
+	if n := len(servers[1].statements("UPDATE account")); n != 1 {
+		t.Fatalf("pending step executed %v times", n)
+	}
+}
+
+func TestSagaNotOpen(t *testing.T) {
+	c, _ := newFakeCluster(t, t.Name(), 1)
+	closed, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name() + "/closed"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSharding(WithMaster(closed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.CreateSagaTable(WithSagaStore(store)); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("CreateSagaTable got %v", err)
+	}
+
+	err = c.Saga("s1", WithSagaStore(store)).
+		Step("debit", []interface{}{int64(0)}, sagaUpdate("account"), nil).
+		Run()
+	if !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("Run got %v", err)
+	}
+}
@@ -0,0 +1,388 @@
+package cluster
+
+import (
+	"container/heap"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShardError 某个库某张表上的查询错误
+type ShardError struct {
+	DBIndex int
+	Table   string
+	Err     error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("db %v table %v: %v", e.DBIndex, e.Table, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ShardErrors 并发查询多个库时收集到的所有错误
+type ShardErrors []*ShardError
+
+func (es ShardErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (es ShardErrors) Unwrap() []error {
+	errs := make([]error, 0, len(es))
+	for _, e := range es {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// fanout 并发在每个库的每张表上执行 fn，同时执行的不超过 WithFanout 的设置，返回所有失败的表。
+// slot 是表按库和表的顺序排列的序号，用来按固定的顺序保存结果，与执行完成的顺序无关
+func (q *Query) fanout(targets []*queryTarget, fn func(slot int, t *queryTarget, table string) error) error {
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		errs ShardErrors
+	)
+
+	sem := make(chan struct{}, q.cluster.opt.fanout)
+	slot := 0
+	for _, t := range targets {
+		for _, table := range t.tables {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(slot int, t *queryTarget, table string) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				if err := fn(slot, t, table); err != nil {
+					mtx.Lock()
+					errs = append(errs, &ShardError{DBIndex: t.index, Table: table, Err: err})
+					mtx.Unlock()
+				}
+			}(slot, t, table)
+			slot++
+		}
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// tableCount 所有库上要查询的表的数量，即 fanout 的 slot 数量
+func tableCount(targets []*queryTarget) int {
+	n := 0
+	for _, t := range targets {
+		n += len(t.tables)
+	}
+	return n
+}
+
+type orderKey struct {
+	index []int
+	desc  bool
+}
+
+// orderKeys 把 Order 的列映射到结果结构体的字段，用于归并各个表的结果
+func orderKeys(elem reflect.Type, orders []interface{}) ([]orderKey, error) {
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+
+	var keys []orderKey
+	for _, o := range orders {
+		s, ok := o.(string)
+		if !ok {
+			return nil, fmt.Errorf("can not merge order %v across shards", o)
+		}
+
+		for _, item := range strings.Split(s, ",") {
+			fields := strings.Fields(item)
+			if len(fields) == 0 {
+				continue
+			}
+
+			if len(fields) > 2 || elem.Kind() != reflect.Struct {
+				return nil, fmt.Errorf("can not merge order %v across shards", item)
+			}
+
+			index := columnField(elem, fields[0])
+			if index == nil {
+				return nil, fmt.Errorf("order column %v not found in %v", fields[0], elem)
+			}
+			keys = append(keys, orderKey{index: index, desc: len(fields) == 2 && strings.EqualFold(fields[1], "desc")})
+		}
+	}
+	return keys, nil
+}
+
+func columnField(typ reflect.Type, column string) []int {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if index := columnField(f.Type, column); index != nil {
+				return append([]int{i}, index...)
+			}
+			continue
+		}
+
+		if f.PkgPath == "" && sameColumn(column, fieldColumn(f)) {
+			return f.Index
+		}
+	}
+	return nil
+}
+
+func compareRows(a, b reflect.Value, keys []orderKey) int {
+	for _, k := range keys {
+		c := compareValues(reflect.Indirect(a).FieldByIndex(k.index), reflect.Indirect(b).FieldByIndex(k.index))
+		if k.desc {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues 和 MySQL 的 ASC 一致，nil 和 NULL 排在最前。sql.NullInt64、mysql.NullTime 等
+// driver.Valuer 按 Value 的结果比较。字符串按字节比较，和 MySQL 的 collation 不同，
+// 比如 utf8mb4_general_ci 不区分大小写，按字符串排序时归并的顺序可能和单表查询不一致
+func compareValues(a, b reflect.Value) int {
+	return compareSortValues(sortValue(a), sortValue(b))
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// sortValue 把字段的值转成 nil、int64、uint64、float64、bool、string 或 time.Time
+func sortValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		return t
+	}
+
+	var valuer driver.Valuer
+	if v.Type().Implements(valuerType) {
+		valuer = v.Interface().(driver.Valuer)
+	} else if v.CanAddr() && v.Addr().Type().Implements(valuerType) {
+		valuer = v.Addr().Interface().(driver.Valuer)
+	}
+
+	if valuer != nil {
+		value, err := valuer.Value()
+		if err != nil || value == nil {
+			return nil
+		}
+		return sortValue(reflect.ValueOf(value))
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func compareSortValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		return boolCompare(a != nil, b != nil)
+	}
+
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return int64Compare(x, y)
+		case uint64:
+			if x < 0 {
+				return -1
+			}
+			return uint64Compare(uint64(x), y)
+		case float64:
+			return float64Compare(float64(x), y)
+		}
+	case uint64:
+		switch y := b.(type) {
+		case uint64:
+			return uint64Compare(x, y)
+		case int64:
+			return -compareSortValues(y, x)
+		case float64:
+			return float64Compare(float64(x), y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case float64:
+			return float64Compare(x, y)
+		case int64, uint64:
+			return -compareSortValues(y, x)
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return boolCompare(x, y)
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			if x.Before(y) {
+				return -1
+			} else if x.After(y) {
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func int64Compare(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func uint64Compare(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func float64Compare(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func boolCompare(a, b bool) int {
+	if a == b {
+		return 0
+	} else if b {
+		return -1
+	}
+	return 1
+}
+
+// mergeHeap 各个表的结果都已经按 keys 排好序，做 k 路归并
+type mergeHeap struct {
+	parts []reflect.Value
+	pos   []int
+	keys  []orderKey
+	items []int
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	c := compareRows(h.parts[a].Index(h.pos[a]), h.parts[b].Index(h.pos[b]), h.keys)
+	if c == 0 {
+		return a < b
+	}
+	return c < 0
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(int)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
+
+// mergeSorted 归并 parts，跳过前 offset 条，最多返回 limit 条，limit < 0 不限制。
+// 排序相同时按 part 的顺序，查询失败的表对应的 part 为零值，当作空的结果。
+// consumed 为每个 part 被归并(包括跳过)的条数
+func mergeSorted(sliceType reflect.Type, parts []reflect.Value, keys []orderKey, offset, limit int) (result reflect.Value, consumed []int) {
+	result = reflect.MakeSlice(sliceType, 0, 0)
+	h := &mergeHeap{parts: parts, pos: make([]int, len(parts)), keys: keys}
+	for i, p := range parts {
+		if p.IsValid() && p.Len() > 0 {
+			h.items = append(h.items, i)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 && (limit < 0 || result.Len() < limit) {
+		i := h.items[0]
+		if offset > 0 {
+			offset--
+		} else {
+			result = reflect.Append(result, h.parts[i].Index(h.pos[i]))
+		}
+
+		h.pos[i]++
+		if h.pos[i] < h.parts[i].Len() {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return result, h.pos
+}
+
+// intValue 转换 Limit/Offset 的值，nil 返回 def
+func intValue(v interface{}, def int) (int, error) {
+	if v == nil {
+		return def, nil
+	}
+
+	switch n := v.(type) {
+	case string:
+		return strconv.Atoi(n)
+	case int:
+		return n, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint()), nil
+	}
+	return 0, fmt.Errorf("invalid limit or offset %v", v)
+}
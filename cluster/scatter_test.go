@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ptrValuer 指针接收者实现的 driver.Valuer
+type ptrValuer struct {
+	v int64
+}
+
+func (p *ptrValuer) Value() (driver.Value, error) {
+	if p.v < 0 {
+		return nil, nil
+	}
+	return p.v, nil
+}
+
+func TestCompareValues(t *testing.T) {
+	now := time.Now()
+	one := int64(1)
+
+	cases := []struct {
+		a, b interface{}
+		want int
+	}{
+		{1, 2, -1},
+		{uint8(3), uint8(3), 0},
+		{2.5, 1.5, 1},
+		{"B", "a", -1},
+		{true, false, 1},
+		{now, now.Add(time.Second), -1},
+		{&one, (*int64)(nil), 1},
+		{sql.NullInt64{Int64: 5, Valid: true}, sql.NullInt64{Int64: 3, Valid: true}, 1},
+		{sql.NullInt64{}, sql.NullInt64{Int64: -3, Valid: true}, -1},
+		{sql.NullString{String: "a", Valid: true}, sql.NullString{String: "b", Valid: true}, -1},
+		{sql.NullTime{Time: now.Add(time.Hour), Valid: true}, sql.NullTime{Time: now, Valid: true}, 1},
+		{sql.NullTime{Time: now, Valid: true}, sql.NullTime{}, 1},
+		{ptrValuer{v: 1}, ptrValuer{v: 2}, -1},
+		{ptrValuer{v: -1}, ptrValuer{v: 2}, -1},
+		{[]byte("b"), []byte("a"), 1},
+	}
+
+	for _, c := range cases {
+		// 放在 slice 中使字段可以取地址，和归并时一样
+		a := reflect.ValueOf([]interface{}{c.a}).Index(0).Elem()
+		b := reflect.ValueOf([]interface{}{c.b}).Index(0).Elem()
+		pa, pb := reflect.New(a.Type()).Elem(), reflect.New(b.Type()).Elem()
+		pa.Set(a)
+		pb.Set(b)
+
+		if got := compareValues(pa, pb); got != c.want {
+			t.Errorf("compare %#v %#v got %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+type mergeRow struct {
+	ID      int64
+	Created sql.NullTime
+}
+
+func TestMergeSorted(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h int) sql.NullTime {
+		return sql.NullTime{Time: base.Add(time.Duration(h) * time.Hour), Valid: true}
+	}
+
+	parts := []reflect.Value{
+		reflect.ValueOf([]mergeRow{{1, sql.NullTime{}}, {2, at(1)}, {3, at(4)}}),
+		reflect.ValueOf([]mergeRow{{4, at(0)}, {5, at(2)}}),
+		reflect.ValueOf([]mergeRow{}),
+		reflect.ValueOf([]mergeRow{{6, at(3)}}),
+	}
+
+	keys, err := orderKeys(reflect.TypeOf(mergeRow{}), []interface{}{"created ASC, id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, consumed := mergeSorted(reflect.TypeOf([]mergeRow{}), parts, keys, 1, 3)
+	var ids []int64
+	for i := 0; i < result.Len(); i++ {
+		ids = append(ids, result.Index(i).Interface().(mergeRow).ID)
+	}
+
+	if !reflect.DeepEqual(ids, []int64{4, 2, 5}) {
+		t.Fatalf("merged %v", ids)
+	}
+
+	if !reflect.DeepEqual(consumed, []int{2, 2, 0, 0}) {
+		t.Fatalf("consumed %v", consumed)
+	}
+
+	if _, err := orderKeys(reflect.TypeOf(mergeRow{}), []interface{}{"missing desc"}); err == nil {
+		t.Fatal("unknown order column accepted")
+	}
+}
+
+func TestFanoutLimit(t *testing.T) {
+	q := &Query{cluster: &Cluster{opt: Options{fanout: 3}}}
+
+	var tables []string
+	for i := 0; i < 20; i++ {
+		tables = append(tables, fmt.Sprintf("t_%d", i))
+	}
+	targets := []*queryTarget{{index: 0, tables: tables[:10]}, {index: 1, tables: tables[10:]}}
+
+	var (
+		running int32
+		max     int32
+		mtx     sync.Mutex
+	)
+	err := q.fanout(targets, func(_ int, target *queryTarget, table string) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		mtx.Lock()
+		if n > max {
+			max = n
+		}
+		mtx.Unlock()
+
+		time.Sleep(time.Millisecond)
+		if table == "t_15" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	if max > 3 {
+		t.Fatalf("%v tables queried concurrently", max)
+	}
+
+	var errs ShardErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].DBIndex != 1 || errs[0].Table != "t_15" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestFindUnorderedIsStable(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	for i, s := range servers {
+		id := int64(i + 1)
+		s.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			return []string{"id", "user_id", "name"}, [][]driver.Value{{id, id, "u"}}
+		})
+	}
+
+	// db 0 最后返回，结果仍然按库的顺序
+	servers[0].setHook(func(query string) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	var users []tagUser
+	if err := c.Model(&tagUser{}).Find(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].ID != 1 || users[1].ID != 2 {
+		t.Fatalf("users = %v, want db 0 then db 1", users)
+	}
+}
@@ -0,0 +1,242 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type SegmentOptions struct {
+	table    string
+	prefetch float64
+	timeout  time.Duration
+}
+
+type SegmentOption func(*SegmentOptions)
+
+// WithSequenceTable 号段表，默认 sequences
+func WithSequenceTable(table string) SegmentOption {
+	return func(o *SegmentOptions) {
+		o.table = table
+	}
+}
+
+// WithPrefetch 当前号段用掉该比例后异步加载下一个号段，默认 0.1
+func WithPrefetch(ratio float64) SegmentOption {
+	return func(o *SegmentOptions) {
+		o.prefetch = ratio
+	}
+}
+
+// WithPrefetchTimeout 异步预加载号段的超时时间，默认 5s，同步加载使用 NextID 的 ctx
+func WithPrefetchTimeout(timeout time.Duration) SegmentOption {
+	return func(o *SegmentOptions) {
+		o.timeout = timeout
+	}
+}
+
+type segment struct {
+	min  int64
+	next int64
+	max  int64
+}
+
+// segmentBuffer 一个序列的双号段，cur 用完时切换到预加载的 next
+type segmentBuffer struct {
+	mtx     sync.Mutex
+	cur     segment
+	next    *segment
+	loading chan struct{}
+	err     error
+}
+
+// SegmentAllocator 号段模式的 ID 分配器，从 store 库 master 上的号段表批量申请 ID：
+//
+//	CREATE TABLE sequences (
+//		name VARCHAR(128) NOT NULL PRIMARY KEY,
+//		max_id BIGINT NOT NULL,
+//		step INT NOT NULL,
+//		updated_at DATETIME NOT NULL
+//	)
+type SegmentAllocator struct {
+	store *Sharding
+	opt   SegmentOptions
+
+	mtx     sync.Mutex
+	buffers map[string]*segmentBuffer
+}
+
+func NewSegmentAllocator(store *Sharding, opts ...SegmentOption) *SegmentAllocator {
+	opt := SegmentOptions{
+		table:    "sequences",
+		prefetch: 0.1,
+		timeout:  5 * time.Second,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &SegmentAllocator{
+		store:   store,
+		opt:     opt,
+		buffers: make(map[string]*segmentBuffer),
+	}
+}
+
+// CreateTable 创建号段表
+func (a *SegmentAllocator) CreateTable() error {
+	master := a.store.Master()
+	if master.db == nil {
+		return ErrNotOpen
+	}
+
+	return master.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name VARCHAR(128) NOT NULL PRIMARY KEY,
+	max_id BIGINT NOT NULL,
+	step INT NOT NULL,
+	updated_at DATETIME NOT NULL
+)`, a.opt.table)).Error
+}
+
+// AddSequence 添加序列，第一个 ID 为 start+1，每次申请 step 个，已存在时忽略
+func (a *SegmentAllocator) AddSequence(name string, start, step int64) error {
+	master := a.store.Master()
+	if master.db == nil {
+		return ErrNotOpen
+	}
+
+	return master.db.Exec(fmt.Sprintf(
+		"INSERT IGNORE INTO %s (name, max_id, step, updated_at) VALUES (?, ?, ?, ?)", a.opt.table),
+		name, start, step, time.Now()).Error
+}
+
+func (a *SegmentAllocator) buffer(name string) *segmentBuffer {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	b, ok := a.buffers[name]
+	if !ok {
+		b = &segmentBuffer{cur: segment{next: 1}}
+		a.buffers[name] = b
+	}
+	return b
+}
+
+// NextID 返回 name 序列的下一个 ID，号段用完且下一个号段还没加载好时等待加载或者 ctx 结束
+func (a *SegmentAllocator) NextID(ctx context.Context, name string) (int64, error) {
+	b := a.buffer(name)
+	for {
+		b.mtx.Lock()
+		if b.cur.next <= b.cur.max {
+			id := b.cur.next
+			b.cur.next++
+
+			used := float64(b.cur.next-b.cur.min) / float64(b.cur.max-b.cur.min+1)
+			if b.next == nil && b.loading == nil && used >= a.opt.prefetch {
+				pctx, cancel := context.WithTimeout(context.Background(), a.opt.timeout)
+				a.load(pctx, cancel, name, b)
+			}
+			b.mtx.Unlock()
+			return id, nil
+		}
+
+		if b.next != nil {
+			b.cur, b.next = *b.next, nil
+			b.mtx.Unlock()
+			continue
+		}
+
+		if b.loading == nil {
+			a.load(ctx, func() {}, name, b)
+		}
+		loading := b.loading
+		b.mtx.Unlock()
+
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+
+		b.mtx.Lock()
+		err := b.err
+		if b.next == nil {
+			b.err = nil
+		}
+		b.mtx.Unlock()
+
+		// 其他调用方的 ctx 结束导致加载失败时重新加载
+		if err != nil && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// load 在 ctx 中异步加载下一个号段，结束后调用 cancel，调用时持有 b.mtx
+func (a *SegmentAllocator) load(ctx context.Context, cancel context.CancelFunc, name string, b *segmentBuffer) {
+	loading := make(chan struct{})
+	b.loading = loading
+	b.err = nil
+
+	go func() {
+		seg, err := a.fetch(ctx, name)
+		cancel()
+
+		b.mtx.Lock()
+		if err != nil {
+			b.err = err
+		} else {
+			b.next = seg
+		}
+		b.loading = nil
+		b.mtx.Unlock()
+		close(loading)
+	}()
+}
+
+// fetch 在事务中把 max_id 增加 step，申请 (max_id-step, max_id] 号段
+func (a *SegmentAllocator) fetch(ctx context.Context, name string) (*segment, error) {
+	master := a.store.Master()
+	if master.db == nil {
+		return nil, ErrNotOpen
+	}
+
+	tx := master.db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	res := tx.Exec(fmt.Sprintf("UPDATE %s SET max_id = max_id + step, updated_at = ? WHERE name = ?", a.opt.table),
+		time.Now(), name)
+	if res.Error != nil {
+		tx.Rollback()
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, fmt.Errorf("sequence %v not found", name)
+	}
+
+	var max, step int64
+	if err := tx.Raw(fmt.Sprintf("SELECT max_id, step FROM %s WHERE name = ?", a.opt.table), name).Row().Scan(&max, &step); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	if step <= 0 {
+		return nil, errors.New("sequence step must be positive")
+	}
+	return &segment{min: max - step + 1, next: max - step + 1, max: max}, nil
+}
@@ -0,0 +1,168 @@
+package cluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// sequenceServer 模拟号段表，每次 UPDATE 把 max_id 增加 step
+func sequenceServer(s *fakeServer, step int64) *int64 {
+	var max int64
+	s.setHook(func(query string) error {
+		if strings.HasPrefix(query, "UPDATE") {
+			atomic.AddInt64(&max, step)
+		}
+		return nil
+	})
+	s.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, "SELECT max_id") {
+			return nil, nil
+		}
+		return []string{"max_id", "step"}, [][]driver.Value{{atomic.LoadInt64(&max), step}}
+	})
+	return &max
+}
+
+func TestSegmentRefill(t *testing.T) {
+	sh, ms, _ := newFakeSharding(t, t.Name(), 0)
+	sequenceServer(ms, 4)
+
+	a := NewSegmentAllocator(sh, WithPrefetch(0.5))
+	for want := int64(1); want <= 10; want++ {
+		id, err := a.NextID(context.Background(), "order")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Fatalf("NextID = %v, want %v", id, want)
+		}
+	}
+
+	// 10 个 ID 用了 3 个号段，预加载最多多申请一个
+	if n := len(ms.statements("UPDATE")); n < 3 || n > 4 {
+		t.Fatalf("fetched %v segments", n)
+	}
+}
+
+func TestSegmentConcurrent(t *testing.T) {
+	sh, ms, _ := newFakeSharding(t, t.Name(), 0)
+	sequenceServer(ms, 10)
+
+	a := NewSegmentAllocator(sh)
+	var (
+		mtx sync.Mutex
+		ids = make(map[int64]bool)
+		wg  sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id, err := a.NextID(context.Background(), "order")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mtx.Lock()
+				if ids[id] {
+					t.Errorf("duplicate id %v", id)
+				}
+				ids[id] = true
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(ids) != 400 {
+		t.Fatalf("got %v ids, want 400", len(ids))
+	}
+}
+
+func TestSegmentFetchError(t *testing.T) {
+	sh, ms, _ := newFakeSharding(t, t.Name(), 0)
+	sequenceServer(ms, 10)
+
+	cause := errors.New("lock wait timeout")
+	ms.setHook(func(query string) error {
+		if strings.HasPrefix(query, "UPDATE") {
+			return cause
+		}
+		return nil
+	})
+
+	a := NewSegmentAllocator(sh)
+	if _, err := a.NextID(context.Background(), "order"); !errors.Is(err, cause) {
+		t.Fatalf("NextID error = %v, want %v", err, cause)
+	}
+	if stmts := ms.statements("ROLLBACK"); len(stmts) != 1 {
+		t.Fatalf("rollback statements = %q", stmts)
+	}
+
+	// 错误不会保留，恢复后重新申请号段
+	sequenceServer(ms, 10)
+	if id, err := a.NextID(context.Background(), "order"); err != nil || id != 1 {
+		t.Fatalf("NextID = %v, %v, want 1", id, err)
+	}
+}
+
+func TestSegmentFetchContext(t *testing.T) {
+	sh, ms, _ := newFakeSharding(t, t.Name(), 0)
+	sequenceServer(ms, 10)
+
+	a := NewSegmentAllocator(sh)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.NextID(ctx, "order"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("NextID error = %v, want context.Canceled", err)
+	}
+	if stmts := ms.statements("UPDATE"); len(stmts) != 0 {
+		t.Fatalf("canceled fetch ran %q", stmts)
+	}
+
+	// 其他调用方的 ctx 取消不影响下一次申请
+	if id, err := a.NextID(context.Background(), "order"); err != nil || id != 1 {
+		t.Fatalf("NextID = %v, %v, want 1", id, err)
+	}
+}
+
+func TestSegmentNotOpen(t *testing.T) {
+	node, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := NewSharding(WithMaster(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewSegmentAllocator(sh)
+	if err := a.CreateTable(); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("CreateTable error = %v, want ErrNotOpen", err)
+	}
+	if _, err := a.NextID(context.Background(), "order"); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("NextID error = %v, want ErrNotOpen", err)
+	}
+}
+
+func TestClusterNextIDLazy(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 1)
+	if c.segment.allocator != nil {
+		t.Fatal("segment allocator created before NextID")
+	}
+
+	sequenceServer(servers[0], 10)
+	if id, err := c.WithContext(context.Background()).NextID(context.Background(), "order"); err != nil || id != 1 {
+		t.Fatalf("NextID = %v, %v, want 1", id, err)
+	}
+	if id, err := c.NextID(context.Background(), "order"); err != nil || id != 2 {
+		t.Fatalf("NextID = %v, %v, want 2", id, err)
+	}
+}
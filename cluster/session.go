@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+type SessionOptions struct {
+	window time.Duration
+	gtid   bool
+}
+
+type SessionOption func(*SessionOptions)
+
+// WithStickyWindow 写入后多长时间内的读走 master，默认 5s
+func WithStickyWindow(d time.Duration) SessionOption {
+	return func(o *SessionOptions) {
+		o.window = d
+	}
+}
+
+// WithGTIDWait 窗口内的读不直接走 master，而是选择已经执行了写入后 master 的 gtid_executed 的 slave，
+// 都没有执行时再走 master，需要开启 GTID
+func WithGTIDWait() SessionOption {
+	return func(o *SessionOptions) {
+		o.gtid = true
+	}
+}
+
+// sessionShard 会话在一个库上的最后一次写入
+type sessionShard struct {
+	written time.Time
+	// pending 写入后还没有读取 master 的 gtid_executed
+	pending bool
+	gtid    string
+	applied map[*ClusterNode]bool
+}
+
+// Session 读自己的写，会话内通过 master 写入某个库后，窗口内对该库的读也走 master。
+// Save/Create/Exec/Update 等写入、Association 的写入和 Transaction 在成功后记录，Model/Where 等链式调用
+// 在执行时才选择节点，不会记录；Begin/Commit/Rollback 返回 master 继续构造，在调用时记录
+type Session struct {
+	cluster *Cluster
+	opt     SessionOptions
+
+	mtx    sync.Mutex
+	shards map[*Sharding]*sessionShard
+}
+
+func (c *Cluster) Session(opts ...SessionOption) *Session {
+	opt := SessionOptions{
+		window: 5 * time.Second,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Session{
+		cluster: c,
+		opt:     opt,
+		shards:  make(map[*Sharding]*sessionShard),
+	}
+}
+
+// DB 同 Cluster.DB，返回的 Sharding 的读写都记录在会话中
+func (s *Session) DB(values ...interface{}) *Sharding {
+	sh := s.cluster.DB(values...)
+	sh.session = s
+	return sh
+}
+
+// Route 同 Cluster.Route
+func (s *Session) Route(value interface{}) *Sharding {
+	sh := s.cluster.Route(value)
+	sh.session = s
+	return sh
+}
+
+// wrote 记录会话通过 master 写入了 sh
+func (s *Session) wrote(sh *Sharding) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.shards[sh.origin()] = &sessionShard{
+		written: time.Now(),
+		pending: s.opt.gtid,
+	}
+}
+
+// filter 从 nodes 中选出可以读到会话写入的节点，没有时返回 master。
+// 查询 gtid 时不持有锁，查询结果只在这期间没有新的写入时保存
+func (s *Session) filter(sh *Sharding, nodes []*ClusterNode) []*ClusterNode {
+	s.mtx.Lock()
+	w, ok := s.shards[sh.origin()]
+	if ok && time.Since(w.written) > s.opt.window {
+		delete(s.shards, sh.origin())
+		ok = false
+	}
+
+	var (
+		pending bool
+		gtid    string
+		applied map[*ClusterNode]bool
+	)
+	if ok {
+		pending, gtid = w.pending, w.gtid
+		applied = make(map[*ClusterNode]bool, len(w.applied))
+		for node := range w.applied {
+			applied[node] = true
+		}
+	}
+	s.mtx.Unlock()
+
+	if !ok {
+		return nodes
+	}
+
+	m := sh.Master()
+	master := []*ClusterNode{m}
+	if !s.opt.gtid {
+		return master
+	}
+
+	// failover 后新的 master 可能还没有 Open，交给 node 返回 ErrNotOpen
+	if m.db == nil {
+		return master
+	}
+
+	if pending {
+		if err := m.db.DB().QueryRowContext(sh.context(), "SELECT @@GLOBAL.gtid_executed").Scan(&gtid); err != nil {
+			return master
+		}
+	}
+
+	var result []*ClusterNode
+	for _, node := range nodes {
+		if node == m || applied[node] || gtidApplied(sh.context(), node, gtid) {
+			applied[node] = true
+			result = append(result, node)
+		}
+	}
+
+	s.mtx.Lock()
+	if s.shards[sh.origin()] == w {
+		w.pending, w.gtid, w.applied = false, gtid, applied
+	}
+	s.mtx.Unlock()
+
+	if len(result) == 0 {
+		return master
+	}
+	return result
+}
+
+// gtidApplied 节点是否已经执行了 gtid 集合中的所有事务
+func gtidApplied(ctx context.Context, node *ClusterNode, gtid string) bool {
+	if node.db == nil {
+		return false
+	}
+
+	var subset sql.NullBool
+	err := node.db.DB().QueryRowContext(ctx, "SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)", gtid).Scan(&subset)
+	return err == nil && subset.Valid && subset.Bool
+}
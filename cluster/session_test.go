@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionWroteAfterSuccess(t *testing.T) {
+	sh, master, slaves := newFakeSharding(t, t.Name(), 1)
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Session()
+
+	master.setHook(func(query string) error {
+		if strings.HasPrefix(query, "INSERT") {
+			return errors.New("duplicate entry")
+		}
+		return nil
+	})
+
+	if err := s.DB(int64(1)).Create(&tagUser{UserID: 1}).Error(); err == nil {
+		t.Fatal("failed insert not reported")
+	}
+
+	var users []tagUser
+	s.DB(int64(1)).Find(&users)
+	if len(slaves[0].statements("SELECT")) != 1 || len(master.statements("SELECT")) != 0 {
+		t.Fatal("failed write made reads sticky to master")
+	}
+
+	master.setHook(nil)
+	if err := s.DB(int64(1)).Create(&tagUser{UserID: 1}).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.DB(int64(1)).Find(&users)
+	if len(master.statements("SELECT")) != 1 {
+		t.Fatal("read after write did not go to master")
+	}
+}
+
+func TestSessionFilterUnlocked(t *testing.T) {
+	sh, master, slaves := newFakeSharding(t, t.Name(), 1)
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Session(WithGTIDWait())
+
+	master.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "gtid_executed") {
+			return []string{"gtid"}, [][]driver.Value{{"uuid:1-5"}}
+		}
+		return nil, nil
+	})
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	slaves[0].setHook(func(query string) error {
+		if strings.Contains(query, "GTID_SUBSET") {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+	slaves[0].setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "GTID_SUBSET") {
+			return []string{"subset"}, [][]driver.Value{{int64(1)}}
+		}
+		return nil, nil
+	})
+
+	s.wrote(sh)
+	done := make(chan []*ClusterNode)
+	go func() {
+		done <- s.filter(sh, sh.replicas())
+	}()
+	<-entered
+
+	// 等待 slave 返回时不阻塞会话的其他操作
+	locked := make(chan struct{})
+	go func() {
+		s.mtx.Lock()
+		s.mtx.Unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("session locked during gtid query")
+	}
+
+	close(release)
+	nodes := <-done
+	if len(nodes) != 1 || nodes[0] != sh.replicas()[0] {
+		t.Fatalf("filter got %v", nodes)
+	}
+
+	// 结果已经保存，不再查询
+	slaves[0].reset()
+	master.reset()
+	if nodes := s.filter(sh, sh.replicas()); len(nodes) != 1 || len(slaves[0].statements("GTID")) != 0 || len(master.statements("gtid")) != 0 {
+		t.Fatalf("filter got %v, queried again", nodes)
+	}
+}
+
+func TestSessionGTIDNotOpen(t *testing.T) {
+	closed, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name() + "/closed"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave, _ := newFakeNode(t, t.Name()+"/slave")
+
+	sh, err := NewSharding(WithMaster(closed), WithSlaves([]*ClusterNode{slave}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Session(WithGTIDWait())
+
+	// 没有 Open 的 master 交给 node 返回 ErrNotOpen
+	s.wrote(sh)
+	var users []tagUser
+	if err := s.DB(int64(1)).Find(&users).Error(); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("find got %v, want ErrNotOpen", err)
+	}
+
+	// 没有 Open 的 slave 不算追上
+	master, ms := newFakeNode(t, t.Name()+"/master")
+	ms.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "gtid_executed") {
+			return []string{"gtid"}, [][]driver.Value{{"uuid:1-5"}}
+		}
+		return nil, nil
+	})
+	sh, err = NewSharding(WithMaster(master), WithSlaves([]*ClusterNode{closed}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wrote(sh)
+	if nodes := s.filter(sh, sh.replicas()); len(nodes) != 1 || nodes[0] != master {
+		t.Fatalf("filter got %v, want master", nodes)
+	}
+}
@@ -1,19 +1,37 @@
 package cluster
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"sync"
+	"time"
 )
 
 type Sharding struct {
+	// master 和 slaves 只保存在 root 上，failover 时整体替换
+	mtx    sync.RWMutex
 	master *ClusterNode
 	slaves []*ClusterNode
 
 	opt ShardingOptions
+	err error
+
+	// staleness 本次查询可以接受的复制延迟
+	staleness time.Duration
+	session   *Session
+	ctx       context.Context
+	// root clone 的来源，标识同一个库
+	root *Sharding
+	// dbNum 所在 Cluster 的库数量，NewCluster 时在复制的 Sharding 上设置，不在 Cluster 中时为 0
+	dbNum int
+	// scopes 链式调用记录的条件，执行时才选择节点并依次应用
+	scopes []func(node *ClusterNode) *ClusterNode
 
 	ShardingValues []interface{}
 }
 
-func NewSharding(opts ...ShardingOption) *Sharding {
+func NewSharding(opts ...ShardingOption) (*Sharding, error) {
 	var opt ShardingOptions
 	for _, o := range opts {
 		o(&opt)
@@ -24,26 +42,149 @@ func NewSharding(opts ...ShardingOption) *Sharding {
 	}
 
 	if opt.master == nil {
-		panic("must has master")
+		return nil, fmt.Errorf("%w: must has master", ErrInvalidConfig)
 	}
 
 	if len(opt.slaves) == 0 {
 		opt.slaves = append(opt.slaves, opt.master)
 	}
 
+	// master 是最后的选择，不熔断
+	for _, s := range opt.slaves {
+		if s != opt.master && s.state != nil {
+			s.state.breaker.policy = opt.readPolicy
+		}
+	}
+
 	return &Sharding{
 		master: opt.master,
 		slaves: opt.slaves,
 		opt:    opt,
-	}
+	}, nil
 }
 
 func (n *Sharding) clone() *Sharding {
 	return &Sharding{
-		master: n.master,
-		slaves: n.slaves,
-		opt:    n.opt,
+		opt:     n.opt,
+		session: n.session,
+		ctx:     n.ctx,
+		root:    n.origin(),
+		dbNum:   n.dbNum,
+	}
+}
+
+// derive 复制路由结果和链式条件，用于返回新的 Sharding 继续构造
+func (n *Sharding) derive() *Sharding {
+	sh := n.clone()
+	sh.ShardingValues = n.ShardingValues
+	sh.err = n.err
+	sh.staleness = n.staleness
+	sh.scopes = n.scopes
+	return sh
+}
+
+// scope 记录一个链式条件，直到 Find、Create 等方法执行时才选择 master 或 slave
+func (n *Sharding) scope(fn func(node *ClusterNode) *ClusterNode) *Sharding {
+	sh := n.derive()
+	sh.scopes = append(n.scopes[:len(n.scopes):len(n.scopes)], fn)
+	return sh
+}
+
+func (n *Sharding) origin() *Sharding {
+	if n.root != nil {
+		return n.root
 	}
+	return n
+}
+
+// Master 返回当前的 master
+func (n *Sharding) Master() *ClusterNode {
+	r := n.origin()
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.master
+}
+
+// nodeOpts 返回 node 的配置，带上所在 Cluster 的库数量，不修改 node
+func (n *Sharding) nodeOpts(node *ClusterNode) NodeOptions {
+	opts := node.opts
+	opts.dbNum = n.dbNum
+	return opts
+}
+
+func (n *Sharding) replicas() []*ClusterNode {
+	r := n.origin()
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.slaves
+}
+
+// node 基于 node 的连接创建一个携带 sharding 值的 ClusterNode 并应用链式条件，路由失败时携带错误
+func (n *Sharding) node(node *ClusterNode) *ClusterNode {
+	db := node.db
+	if db != nil && n.ctx != nil {
+		db = node.contextDB(n.ctx)
+	}
+
+	cn := &ClusterNode{db: db, opts: n.nodeOpts(node), state: node.state, ShardingValues: n.ShardingValues}
+	switch {
+	case n.err != nil:
+		return cn.withError(n.err)
+	case db == nil:
+		return cn.withError(ErrNotOpen)
+	}
+
+	for _, fn := range n.scopes {
+		cn = fn(cn)
+	}
+	return cn
+}
+
+func (n *Sharding) primary() *ClusterNode {
+	return n.node(n.Master())
+}
+
+// write 在 master 上执行写入，成功后在会话中记录
+func (n *Sharding) write(fn func(node *ClusterNode) *ClusterNode) *ClusterNode {
+	node := fn(n.primary())
+	if n.session != nil && node.Error() == nil {
+		n.session.wrote(n)
+	}
+	return node
+}
+
+// pin 返回 master 继续构造写入，之后的写入不经过 Sharding，所以现在就在会话中记录
+func (n *Sharding) pin() *ClusterNode {
+	if n.session != nil && n.err == nil {
+		n.session.wrote(n)
+	}
+	return n.primary()
+}
+
+// slave 选择一个可以读的节点，不占用熔断器的试探请求，用在不经过 Read 记录结果的地方
+func (n *Sharding) slave() *ClusterNode {
+	return n.node(n.choose())
+}
+
+// pick 选择一个节点给 Read 执行，熔断超时的节点进入半开，由 Read 记录结果
+func (n *Sharding) pick() *ClusterNode {
+	node := n.choose()
+	node.breaker().acquire()
+	return node
+}
+
+// choose 用 Balancer 从可以读的节点中选择一个
+func (n *Sharding) choose() *ClusterNode {
+	nodes := n.candidates()
+	if n.session != nil {
+		nodes = n.session.filter(n, nodes)
+	}
+	return n.opt.balancer.Next(nodes)
+}
+
+// Error 返回路由到该库时产生的错误
+func (n *Sharding) Error() error {
+	return n.err
 }
 
 func (n *Sharding) SetBalancer(balancer Balancer) {
@@ -51,19 +192,24 @@ func (n *Sharding) SetBalancer(balancer Balancer) {
 }
 
 func (n *Sharding) ClusterNode(fn func(node *ClusterNode)) {
-	fn(n.master)
-	for _, s := range n.slaves {
+	fn(n.Master())
+	for _, s := range n.replicas() {
 		fn(s)
 	}
 }
 
 func (n *Sharding) Open() error {
-	if err := n.master.Open(); err != nil {
+	return n.OpenContext(context.Background())
+}
+
+// OpenContext 连接 master 和所有 slave，ctx 控制建立连接的超时
+func (n *Sharding) OpenContext(ctx context.Context) error {
+	if err := n.Master().OpenContext(ctx); err != nil {
 		return err
 	}
 
-	for _, slave := range n.slaves {
-		if err := slave.Open(); err != nil {
+	for _, slave := range n.replicas() {
+		if err := slave.OpenContext(ctx); err != nil {
 			return err
 		}
 	}
@@ -72,130 +218,170 @@ func (n *Sharding) Open() error {
 
 // Save update value in database, if the value doesn't have primary key, will insert it
 func (n *Sharding) Save(value interface{}) *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Save(value)
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.Save(value)
+	})
 }
 
 // Create insert the value into database
 func (n *Sharding) Create(value interface{}) *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Create(value)
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.Create(value)
+	})
 }
 
 // Delete delete value match given conditions, if the value has primary key, then will including the primary key as condition
 func (n *Sharding) Delete(value interface{}) *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Delete(value)
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.Delete(value)
+	})
 }
 
 // Scan scan value to a struct
 func (n *Sharding) Scan(dest interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Scan(dest)
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.Scan(dest)
+	})
 }
 
 // Row return `*sql.Row` with given conditions
-func (n *Sharding) Row() *sql.Row {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Row()
+func (n *Sharding) Row() (row *sql.Row) {
+	if n.err != nil {
+		return errRow(n.err)
+	}
+
+	n.Read(func(node *ClusterNode) error {
+		row = node.Row()
+		return row.Err()
+	})
+	return row
 }
 
 // Rows return `*sql.Rows` with given conditions
-func (n *Sharding) Rows() (*sql.Rows, error) {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Rows()
+func (n *Sharding) Rows() (rows *sql.Rows, err error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+
+	err = n.Read(func(node *ClusterNode) error {
+		rows, err = node.Rows()
+		return err
+	})
+	return rows, err
 }
 
 // ScanRows scan `*sql.Rows` to give struct
 func (n *Sharding) ScanRows(rows *sql.Rows, result interface{}) error {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).ScanRows(rows, result)
+	return n.slave().ScanRows(rows, result)
 }
 
-func (n *Sharding) Raw(sql string, values ...interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Raw(sql, values)
+// Raw use raw sql as conditions, won't run it unless invoked by other methods
+//
+//	db.Raw("SELECT name, age FROM users WHERE name = ?", 3).Scan(&result)
+func (n *Sharding) Raw(sql string, values ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Raw(sql, values...)
+	})
 }
 
+// Exec execute raw sql
 func (n *Sharding) Exec(sql string, values ...interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Exec(sql, values)
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.Exec(sql, values...)
+	})
 }
 
 // FirstOrCreate find first matched record or create a new one with given conditions (only works with struct, map conditions)
 // https://jinzhu.github.io/gorm/crud.html#firstorcreate
 func (n *Sharding) FirstOrCreate(out interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).FirstOrCreate(out)
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.FirstOrCreate(out)
+	})
 }
 
 // First find first record that match given conditions, order by primary key
 func (n *Sharding) First(out interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).First(out)
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.First(out)
+	})
 }
 
+// Last find last record that match given conditions, order by primary key
 func (n *Sharding) Last(out interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Last(out)
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.Last(out)
+	})
 }
 
 // Updates update attributes with callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
 func (n *Sharding) Updates(values interface{}) *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Updates(values)
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.Updates(values)
+	})
 }
 
 // UpdateColumns update attributes without callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
 func (n *Sharding) UpdateColumns(values interface{}) *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).UpdateColumns(values)
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.UpdateColumns(values)
+	})
 }
 
 // Begin begin a transaction
 func (n *Sharding) Begin() *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Begin()
+	return n.pin().Begin()
 }
 
 // Commit commit a transaction
 func (n *Sharding) Commit() *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Commit()
+	return n.pin().Commit()
 }
 
 // Rollback rollback a transaction
 func (n *Sharding) Rollback() *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Rollback()
+	return n.pin().Rollback()
 }
 
 // Find find records that match given conditions
 func (n *Sharding) Find(out interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Find(out)
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.Find(out)
+	})
 }
 
 // Where return a new relation, filter records with given conditions, accepts `map`, `struct` or `string` as conditions, refer http://jinzhu.github.io/gorm/crud.html#query
-func (n *Sharding) Where(query interface{}, args ...interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Where(query, args...)
+func (n *Sharding) Where(query interface{}, args ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Where(query, args...)
+	})
 }
 
 // Or filter records that match before conditions or this one, similar to `Where`
-func (n *Sharding) Or(query interface{}, args ...interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Or(query, args...)
+func (n *Sharding) Or(query interface{}, args ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Or(query, args...)
+	})
 }
 
 // Not filter records that don't match current conditions, similar to `Where`
-func (n *Sharding) Not(query interface{}, args ...interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Not(query, args...)
+func (n *Sharding) Not(query interface{}, args ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Not(query, args...)
+	})
 }
 
 // Limit specify the number of records to be retrieved
-func (n *Sharding) Limit(limit interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Limit(limit)
+func (n *Sharding) Limit(limit interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Limit(limit)
+	})
 }
 
 // Offset specify the number of records to skip before starting to return the records
-func (n *Sharding) Offset(offset interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Offset(offset)
+func (n *Sharding) Offset(offset interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Offset(offset)
+	})
 }
 
 // Model specify the model you would like to run db operations
@@ -203,28 +389,158 @@ func (n *Sharding) Offset(offset interface{}) *ClusterNode {
 //    db.Model(&User{}).Update("name", "hello")
 //    // if user's primary key is non-blank, will use it as condition, then will only update the user's name to `hello`
 //    db.Model(&user).Update("name", "hello")
-func (n *Sharding) Model(value interface{}) *ClusterNode {
-	return (&ClusterNode{db: n.master.db, opts: n.master.opts, ShardingValues: n.ShardingValues}).Model(value)
+func (n *Sharding) Model(value interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Model(value)
+	})
 }
 
 // Order specify order when retrieve records from database, set reorder to `true` to overwrite defined conditions
 //     db.Order("name DESC")
 //     db.Order("name DESC", true) // reorder
 //     db.Order(gorm.Expr("name = ? DESC", "first")) // sql expression
-func (n *Sharding) Order(value interface{}, reorder ...bool) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Order(value, reorder...)
+func (n *Sharding) Order(value interface{}, reorder ...bool) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Order(value, reorder...)
+	})
 }
 
 // Select specify fields that you want to retrieve from database when querying, by default, will select all fields;
 // When creating/updating, specify fields that you want to save to database
-func (n *Sharding) Select(query interface{}, args ...interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Select(query, args...)
+func (n *Sharding) Select(query interface{}, args ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Select(query, args...)
+	})
+}
+
+// Table 使用 values 作为分表的 sharding 值
+func (n *Sharding) Table(values ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Table(values...)
+	})
 }
 
 // Count get how many records for a model
 func (n *Sharding) Count(value interface{}) *ClusterNode {
-	node := n.opt.balancer.Next(n.slaves)
-	return (&ClusterNode{db: node.db, opts: node.opts, ShardingValues: n.ShardingValues}).Count(value)
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.Count(value)
+	})
+}
+
+// Update update attributes with callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
+func (n *Sharding) Update(attrs ...interface{}) *ClusterNode {
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.Update(attrs...)
+	})
+}
+
+// UpdateColumn update attributes without callbacks, refer: https://jinzhu.github.io/gorm/crud.html#update
+func (n *Sharding) UpdateColumn(attrs ...interface{}) *ClusterNode {
+	return n.write(func(node *ClusterNode) *ClusterNode {
+		return node.UpdateColumn(attrs...)
+	})
+}
+
+// Pluck used to query single column from a model as a map
+//
+//	var ages []int64
+//	db.Find(&users).Pluck("age", &ages)
+func (n *Sharding) Pluck(column string, value interface{}) *ClusterNode {
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.Pluck(column, value)
+	})
+}
+
+// Take return a record that match given conditions, the order will depend on the database implementation
+func (n *Sharding) Take(out interface{}) *ClusterNode {
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.Take(out)
+	})
+}
+
+// FirstOrInit find first matched record or initialize a new one with given conditions (only works with struct, map conditions)
+// https://jinzhu.github.io/gorm/crud.html#firstorinit
+func (n *Sharding) FirstOrInit(out interface{}) *ClusterNode {
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.FirstOrInit(out)
+	})
+}
+
+// Related get related associations
+func (n *Sharding) Related(value interface{}, foreignKeys ...string) *ClusterNode {
+	return n.read(func(node *ClusterNode) *ClusterNode {
+		return node.Related(value, foreignKeys...)
+	})
+}
+
+// Group specify the group method on the find
+func (n *Sharding) Group(query string) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Group(query)
+	})
+}
+
+// Having specify HAVING conditions for GROUP BY
+func (n *Sharding) Having(query interface{}, values ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Having(query, values...)
+	})
+}
+
+// Joins specify Joins conditions
+//
+//	db.Joins("JOIN emails ON emails.user_id = users.id AND emails.email = ?", "jinzhu@example.org").Find(&user)
+func (n *Sharding) Joins(query string, args ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Joins(query, args...)
+	})
+}
+
+// Preload preload associations with given conditions
+//
+//	db.Preload("Orders", "state NOT IN (?)", "cancelled").Find(&users)
+func (n *Sharding) Preload(column string, conditions ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Preload(column, conditions...)
+	})
+}
+
+// Unscoped return all record including deleted record, refer Soft Delete https://jinzhu.github.io/gorm/crud.html#soft-delete
+func (n *Sharding) Unscoped() *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Unscoped()
+	})
+}
+
+// Attrs initialize struct with argument if record not found with given conditions, refer https://jinzhu.github.io/gorm/crud.html#firstorinit
+func (n *Sharding) Attrs(attrs ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Attrs(attrs...)
+	})
+}
+
+// Assign assign result with argument regardless it is found or not with FirstOrInit https://jinzhu.github.io/gorm/crud.html#firstorinit or FirstOrCreate https://jinzhu.github.io/gorm/crud.html#firstorcreate
+func (n *Sharding) Assign(attrs ...interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Assign(attrs...)
+	})
+}
+
+// Set set setting by name, which could be used in callbacks, will clone a new db, and update its setting
+func (n *Sharding) Set(name string, value interface{}) *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Set(name, value)
+	})
+}
+
+// Get get setting by name
+func (n *Sharding) Get(name string) (value interface{}, ok bool) {
+	return n.primary().Get(name)
+}
+
+// Debug start debug mode
+func (n *Sharding) Debug() *Sharding {
+	return n.scope(func(node *ClusterNode) *ClusterNode {
+		return node.Debug()
+	})
 }
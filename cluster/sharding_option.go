@@ -14,6 +14,10 @@ type ShardingOptions struct {
 	balancer Balancer
 	master   *ClusterNode
 	slaves   []*ClusterNode
+	maxLag   time.Duration
+
+	readPolicy *ReadPolicy
+	onFailover func(e FailoverEvent)
 }
 
 type Balancer interface {
@@ -40,6 +44,21 @@ func WithSlaves(s []*ClusterNode) ShardingOption {
 	}
 }
 
+// WithMaxLag 复制延迟超过 d 的 slave 不参与读，延迟由 HealthChecker 的 LagProbe 检查，
+// 没有检查过延迟的 slave 也不参与读
+func WithMaxLag(d time.Duration) ShardingOption {
+	return func(o *ShardingOptions) {
+		o.maxLag = d
+	}
+}
+
+// WithReadPolicy slave 读的熔断和重试策略，默认不熔断也不重试
+func WithReadPolicy(p ReadPolicy) ShardingOption {
+	return func(o *ShardingOptions) {
+		o.readPolicy = &p
+	}
+}
+
 type BalancerFunc func([]*ClusterNode) *ClusterNode
 
 func (d BalancerFunc) Next(s []*ClusterNode) *ClusterNode {
@@ -66,3 +85,69 @@ func Random() BalancerFunc {
 		return nodes[rand.Int()%len(nodes)]
 	}
 }
+
+// WeightedRoundRobin 平滑加权轮询，按 DB.Weight 分配读，同一个节点不会被连续选中太多次。
+// 每次只保留本次参与选择的节点，被剔除或者替换的节点不会一直留在状态中
+func WeightedRoundRobin() BalancerFunc {
+	var mtx sync.Mutex
+	current := make(map[*ClusterNode]int)
+	return func(nodes []*ClusterNode) *ClusterNode {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		var best *ClusterNode
+		total := 0
+		next := make(map[*ClusterNode]int, len(nodes))
+		for _, node := range nodes {
+			w := node.Weight()
+			total += w
+			next[node] = current[node] + w
+			if best == nil || next[node] > next[best] {
+				best = node
+			}
+		}
+		next[best] -= total
+		current = next
+		return best
+	}
+}
+
+// LeastInFlight 选择连接池中正在使用的连接最少的节点
+func LeastInFlight() BalancerFunc {
+	return func(nodes []*ClusterNode) *ClusterNode {
+		// 从随机位置开始，使用数相同时分散到不同节点
+		start := rand.Int() % len(nodes)
+		best, least := nodes[start], -1
+		for i := range nodes {
+			node := nodes[(start+i)%len(nodes)]
+			if node.db == nil {
+				continue
+			}
+
+			if inUse := node.db.DB().Stats().InUse; least < 0 || inUse < least {
+				best, least = node, inUse
+			}
+		}
+		return best
+	}
+}
+
+// PowerOfTwoChoices 随机选两个节点，选择耗时和错误率的 EWMA 较低的一个，慢的节点自动减少流量
+func PowerOfTwoChoices() BalancerFunc {
+	return func(nodes []*ClusterNode) *ClusterNode {
+		if len(nodes) == 1 {
+			return nodes[0]
+		}
+
+		i := rand.Intn(len(nodes))
+		j := rand.Intn(len(nodes) - 1)
+		if j >= i {
+			j++
+		}
+
+		if nodes[j].cost() < nodes[i].cost() {
+			return nodes[j]
+		}
+		return nodes[i]
+	}
+}
@@ -0,0 +1,199 @@
+package cluster
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-gorm/gorm"
+)
+
+type assocUser struct {
+	ID     int64
+	Orders []assocOrder
+}
+
+func (assocUser) TableName() string {
+	return "assoc_user"
+}
+
+type assocOrder struct {
+	ID          int64
+	AssocUserID int64
+}
+
+func (assocOrder) TableName() string {
+	return "assoc_order"
+}
+
+// newRoutingCluster 创建一个有一个 slave 的库，DB(int64(1)) 路由到该库
+func newRoutingCluster(t *testing.T) (*Cluster, *Sharding, *fakeServer, *fakeServer) {
+	t.Helper()
+
+	sh, master, slaves := newFakeSharding(t, t.Name(), 1)
+	c, err := NewCluster(WithShardings(sh))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, sh, master, slaves[0]
+}
+
+func TestShardingRouting(t *testing.T) {
+	c, _, master, slave := newRoutingCluster(t)
+
+	var (
+		users []tagUser
+		user  tagUser
+		n     int64
+		names []string
+	)
+	cases := []struct {
+		name  string
+		write bool
+		run   func(sh *Sharding) error
+	}{
+		{"Where.Find", false, func(sh *Sharding) error { return sh.Where("name = ?", "a").Find(&users).Error() }},
+		{"Or.Not.First", false, func(sh *Sharding) error { return sh.Not("id = ?", 1).Or("id = ?", 2).First(&user).Error() }},
+		{"Order.Limit.Offset.Find", false, func(sh *Sharding) error { return sh.Order("id").Limit(1).Offset(1).Find(&users).Error() }},
+		{"Model.Count", false, func(sh *Sharding) error { return sh.Model(&tagUser{}).Count(&n).Error() }},
+		{"Model.Pluck", false, func(sh *Sharding) error { return sh.Model(&tagUser{}).Pluck("name", &names).Error() }},
+		{"Select.Group.Having.Find", false, func(sh *Sharding) error {
+			return sh.Select("name").Group("name").Having("count(*) > ?", 1).Find(&users).Error()
+		}},
+		{"Joins.Find", false, func(sh *Sharding) error { return sh.Joins("JOIN x ON x.id = tag_user.id").Find(&users).Error() }},
+		{"Unscoped.Find", false, func(sh *Sharding) error { return sh.Unscoped().Find(&users).Error() }},
+		{"Raw.Scan", false, func(sh *Sharding) error { return sh.Raw("SELECT name FROM tag_user").Scan(&users).Error() }},
+		{"Attrs.FirstOrInit", false, func(sh *Sharding) error {
+			return sh.Where("name = ?", "a").Attrs(tagUser{Name: "b"}).FirstOrInit(&tagUser{}).Error()
+		}},
+
+		{"Create", true, func(sh *Sharding) error { return sh.Create(&tagUser{UserID: 1}).Error() }},
+		{"Set.Create", true, func(sh *Sharding) error {
+			return sh.Set("gorm:insert_option", "ON DUPLICATE KEY UPDATE name = name").Create(&tagUser{UserID: 1}).Error()
+		}},
+		{"Save", true, func(sh *Sharding) error { return sh.Save(&tagUser{ID: 1, UserID: 1}).Error() }},
+		{"Where.Delete", true, func(sh *Sharding) error { return sh.Where("name = ?", "a").Delete(&tagUser{}).Error() }},
+		{"Model.Update", true, func(sh *Sharding) error { return sh.Model(&tagUser{}).Where("id = ?", 1).Update("name", "a").Error() }},
+		{"Model.Updates", true, func(sh *Sharding) error {
+			return sh.Model(&tagUser{}).Where("id = ?", 1).Updates(map[string]interface{}{"name": "a"}).Error()
+		}},
+		{"Model.UpdateColumn", true, func(sh *Sharding) error {
+			return sh.Model(&tagUser{}).Where("id = ?", 1).UpdateColumn("name", "a").Error()
+		}},
+		{"Model.UpdateColumns", true, func(sh *Sharding) error {
+			return sh.Model(&tagUser{}).Where("id = ?", 1).UpdateColumns(map[string]interface{}{"name": "a"}).Error()
+		}},
+		{"Where.Attrs.FirstOrCreate", true, func(sh *Sharding) error {
+			return sh.Where("name = ?", "a").Attrs(tagUser{UserID: 1}).FirstOrCreate(&tagUser{}).Error()
+		}},
+		{"Assign.FirstOrCreate", true, func(sh *Sharding) error {
+			return sh.Where("name = ?", "a").Assign(tagUser{UserID: 1}).FirstOrCreate(&tagUser{}).Error()
+		}},
+		{"Exec", true, func(sh *Sharding) error { return sh.Exec("UPDATE tag_user SET name = ?", "a").Error() }},
+	}
+
+	for _, tc := range cases {
+		master.reset()
+		slave.reset()
+
+		if err := tc.run(c.DB(int64(1))); err != nil && !gorm.IsRecordNotFoundError(err) {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+
+		onMaster, onSlave := len(master.statements("")) > 0, len(slave.statements("")) > 0
+		if tc.write && (!onMaster || onSlave) {
+			t.Errorf("%v: master %q, slave %q, want master", tc.name, master.statements(""), slave.statements(""))
+		}
+		if !tc.write && (onMaster || !onSlave) {
+			t.Errorf("%v: master %q, slave %q, want slave", tc.name, master.statements(""), slave.statements(""))
+		}
+	}
+}
+
+func TestShardingChainIsLazy(t *testing.T) {
+	c, _, master, slave := newRoutingCluster(t)
+
+	// 条件在执行之前不会选择节点，也不会影响原来的 Sharding
+	sh := c.DB(int64(1))
+	where := sh.Where("name = ?", "a")
+	if v, ok := sh.Set("k", "v").Get("k"); !ok || v != "v" {
+		t.Fatalf("Get = %v, %v, want v", v, ok)
+	}
+	if _, ok := sh.Get("k"); ok {
+		t.Fatal("Set changed the original sharding")
+	}
+
+	var users []tagUser
+	if err := sh.Find(&users).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if err := where.Limit(1).Find(&users).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	stmts := slave.statements("SELECT")
+	if len(stmts) != 2 || strings.TrimSpace(stmts[0]) != "SELECT * FROM `tag_user`" {
+		t.Fatalf("statements = %q, want conditions only on the chained query", stmts)
+	}
+	if len(master.statements("")) != 0 {
+		t.Fatalf("master statements = %q, want none", master.statements(""))
+	}
+}
+
+func TestShardingAssociation(t *testing.T) {
+	c, sh, master, slave := newRoutingCluster(t)
+	s := c.Session()
+
+	user := &assocUser{ID: 1}
+	if err := s.DB(int64(1)).Model(user).Association("Orders").Append(&assocOrder{ID: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(master.statements("assoc_order")) == 0 || len(slave.statements("")) != 0 {
+		t.Fatalf("master %q, slave %q, want association on master", master.statements(""), slave.statements(""))
+	}
+	if sh.Master().state.stats.samples == 0 {
+		t.Fatal("association latency not recorded")
+	}
+
+	// 写入成功后会话的读也在 master 上
+	var users []tagUser
+	master.reset()
+	s.DB(int64(1)).Find(&users)
+	if len(master.statements("SELECT")) != 1 {
+		t.Fatal("read after association write did not go to master")
+	}
+
+	if n := c.DB(int64(1)).Model(user).Association("Orders").Count(); n != 0 {
+		t.Fatalf("Count = %d, want 0", n)
+	}
+
+	// master 切换后旧 master 上的写入返回 ErrMasterChanged
+	assoc := c.DB(int64(1)).Model(user).Association("Orders")
+	node, _ := newFakeNode(t, t.Name()+"/new")
+	if err := sh.SetMaster(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := assoc.Append(&assocOrder{ID: 3}).Error; !errors.Is(err, ErrMasterChanged) {
+		t.Fatalf("Append error = %v, want ErrMasterChanged", err)
+	}
+}
+
+func TestShardingAssociationError(t *testing.T) {
+	c, _, _, _ := newRoutingCluster(t)
+
+	assoc := c.DB(int64(1)).Model(&assocUser{ID: 1}).Association("Missing")
+	if assoc.Error == nil {
+		t.Fatal("Association on missing column succeeded")
+	}
+	if err := assoc.Append(&assocOrder{ID: 2}).Error; err == nil {
+		t.Fatal("Append after error succeeded")
+	}
+
+	cause := errors.New("route failed")
+	sh := c.DB(int64(1))
+	sh.err = cause
+	if err := sh.Model(&assocUser{ID: 1}).Association("Orders").Error; !errors.Is(err, cause) {
+		t.Fatalf("Association error = %v, want route error", err)
+	}
+}
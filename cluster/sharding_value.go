@@ -1,5 +1,26 @@
 package cluster
 
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoShardKey         = errors.New("no sharding key")
+	ErrZeroShardKey       = errors.New("sharding key is zero value")
+	ErrUnsupportedKeyType = errors.New("unsupported sharding key type")
+	ErrNoTableName        = errors.New("model has no TableName method")
+	ErrInvalidConfig      = errors.New("invalid config")
+)
+
+// ShardKeyTag 标记 model 的 sharding 字段，如 `gorm-cluster:"shard_key"`
+const ShardKeyTag = "gorm-cluster"
+
 type ShardingValue struct {
 	value         interface{}
 	tableSelector TableSelector
@@ -7,19 +28,159 @@ type ShardingValue struct {
 	dbIndex       int
 
 	shradingValues []interface{}
+	// err TableName 路由失败的错误
+	err error
 }
 
-func (s ShardingValue) TableName() (name string) {
+// TableName 返回路由到的表名，路由失败时返回空字符串，错误保存在 Err 中
+func (s *ShardingValue) TableName() (name string) {
+	name, s.err = s.RouteTableName()
+	return name
+}
+
+// Err 返回 TableName 路由失败的错误
+func (s *ShardingValue) Err() error {
+	return s.err
+}
+
+// RouteTableName 返回 value 路由到的表名
+func (s *ShardingValue) RouteTableName() (name string, err error) {
 	tn, ok := s.value.(TableName)
 	if !ok {
-		panic("has not TableName method。")
+		return "", fmt.Errorf("%w: %T", ErrNoTableName, s.value)
+	}
+
+	router, ok := s.tableSelector.(TableRouter)
+	if ok {
+		return router.RouteTable(tn.TableName(), s.tableNum, s.dbIndex, s.shradingValues...)
 	}
 
-	name = tn.TableName()
-	// 获取 sharding 数据
-	return s.tableSelector.Table(name, s.tableNum, s.dbIndex, s.shradingValues...)
+	// 只实现了 TableSelector 的选择器用 panic 表示不支持的值
+	defer func() {
+		if r := recover(); r != nil {
+			err = selectorError(r)
+		}
+	}()
+	return s.tableSelector.Table(tn.TableName(), s.tableNum, s.dbIndex, s.shradingValues...), nil
+}
+
+// selectorError 把选择器用 panic 表示的不支持的值转换为错误，runtime.Error 是选择器的 bug，继续 panic
+func selectorError(r interface{}) error {
+	if re, ok := r.(runtime.Error); ok {
+		panic(re)
+	}
+	return fmt.Errorf("%w: %v", ErrUnsupportedKeyType, r)
 }
 
 type TableName interface {
 	TableName() string
 }
+
+var shardKeyFields sync.Map // reflect.Type -> []int
+
+// ShardKey 返回 value 中标记了 `gorm-cluster:"shard_key"` 的字段值，内置整数类型统一为 int64
+func ShardKey(value interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("%w: %T is nil", ErrNoShardKey, value)
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %T is not struct", ErrNoShardKey, value)
+	}
+
+	index := shardKeyField(rv.Type())
+	if index == nil {
+		return nil, fmt.Errorf("%w: %v has no `%v:\"shard_key\"` field", ErrNoShardKey, rv.Type(), ShardKeyTag)
+	}
+
+	field, err := rv.FieldByIndexErr(index)
+	if err != nil || field.IsZero() {
+		return nil, fmt.Errorf("%w: %v.%v", ErrZeroShardKey, rv.Type(), rv.Type().FieldByIndex(index).Name)
+	}
+	return int64Key(field.Interface()), nil
+}
+
+// int64Key 把内置整数类型的 sharding 值统一为 int64，默认的选择器只接受 int64。
+// 自定义类型如 SnowflakeID 保持不变，选择器可能按类型路由，超过 int64 的无符号数也保持不变
+func int64Key(key interface{}) interface{} {
+	switch v := key.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return uint64Key(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return uint64Key(v)
+	}
+	return key
+}
+
+func uint64Key(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return v
+	}
+	return int64(v)
+}
+
+func shardKeyField(typ reflect.Type) []int {
+	if index, ok := shardKeyFields.Load(typ); ok {
+		return index.([]int)
+	}
+
+	index := findShardKeyField(typ)
+	shardKeyFields.Store(typ, index)
+	return index
+}
+
+func findShardKeyField(typ reflect.Type) []int {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		for _, opt := range strings.Split(f.Tag.Get(ShardKeyTag), ",") {
+			if strings.TrimSpace(opt) == "shard_key" {
+				return f.Index
+			}
+		}
+	}
+
+	// 嵌入的结构体
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if !f.Anonymous || ft.Kind() != reflect.Struct {
+			continue
+		}
+
+		if index := findShardKeyField(ft); index != nil {
+			return append([]int{i}, index...)
+		}
+	}
+	return nil
+}
+
+// newElem 返回 out 元素类型的指针，如 *[]User -> *User
+func newElem(out interface{}) interface{} {
+	typ := reflect.TypeOf(out)
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+		typ = typ.Elem()
+	}
+	return reflect.New(typ).Interface()
+}
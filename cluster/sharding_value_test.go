@@ -0,0 +1,150 @@
+package cluster
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type tagUser struct {
+	ID     int64
+	UserID int64 `gorm-cluster:"shard_key"`
+	Name   string
+}
+
+func (tagUser) TableName() string {
+	return "tag_user"
+}
+
+type tagBase struct {
+	TenantID string `gorm-cluster:"shard_key"`
+}
+
+type tagEmbedded struct {
+	tagBase
+	Name string
+}
+
+type tagFloat struct {
+	ID    int64
+	Score float64 `gorm-cluster:"shard_key"`
+}
+
+func (tagFloat) TableName() string {
+	return "tag_float"
+}
+
+type tagInt struct {
+	ID     int64
+	UserID int `gorm-cluster:"shard_key"`
+}
+
+func (tagInt) TableName() string {
+	return "tag_int"
+}
+
+type tagMissing struct {
+	ID int64
+}
+
+func (tagMissing) TableName() string {
+	return "tag_missing"
+}
+
+func TestShardKey(t *testing.T) {
+	cases := []struct {
+		name  string
+		value interface{}
+		key   interface{}
+		err   error
+	}{
+		{"tagged", &tagUser{UserID: 7}, int64(7), nil},
+		{"value", tagUser{UserID: 7}, int64(7), nil},
+		{"int", &tagInt{UserID: 7}, int64(7), nil},
+		{"snowflake id", struct {
+			ID SnowflakeID `gorm-cluster:"shard_key"`
+		}{7}, SnowflakeID(7), nil},
+		{"embedded", &tagEmbedded{tagBase: tagBase{TenantID: "t1"}}, "t1", nil},
+		{"missing tag", &tagMissing{ID: 1}, nil, ErrNoShardKey},
+		{"zero key", &tagUser{ID: 1}, nil, ErrZeroShardKey},
+		{"nil pointer", (*tagUser)(nil), nil, ErrNoShardKey},
+		{"not struct", []tagUser{{UserID: 1}}, nil, ErrNoShardKey},
+	}
+
+	for _, tc := range cases {
+		key, err := ShardKey(tc.value)
+		if !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+			t.Errorf("%v: error = %v, want %v", tc.name, err, tc.err)
+			continue
+		}
+		if key != tc.key {
+			t.Errorf("%v: key = %v, want %v", tc.name, key, tc.key)
+		}
+	}
+}
+
+func TestRouteByShardKey(t *testing.T) {
+	node, s := newFakeNode(t, t.Name(), WithTableNum(4))
+
+	if err := node.Create(&tagUser{UserID: 6}).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if stmts := s.statements("INSERT"); len(stmts) != 1 || !strings.Contains(stmts[0], "`tag_user_00000002`") {
+		t.Fatalf("statements = %q, want routed to tag_user_00000002", stmts)
+	}
+
+	// int 的 shard_key 和 int64 路由到同一张表
+	if err := node.Create(&tagInt{UserID: 6}).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if stmts := s.statements("INSERT"); len(stmts) != 2 || !strings.Contains(stmts[1], "`tag_int_00000002`") {
+		t.Fatalf("statements = %q, want routed to tag_int_00000002", stmts)
+	}
+	c2, servers := newFakeCluster(t, t.Name()+"/route", 2)
+	if err := c2.Route(&tagInt{UserID: 3}).Create(&tagInt{UserID: 3}).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if len(servers[1].statements("INSERT")) != 1 {
+		t.Fatal("int shard_key not routed to db 1")
+	}
+
+	// 分库不分表时也必须有 shard_key
+	c, _ := newFakeCluster(t, t.Name()+"/dbs", 2)
+	for _, n := range []*ClusterNode{node, c.shardingList[0].primary()} {
+		if err := n.Create(&tagMissing{ID: 1}).Error(); !errors.Is(err, ErrNoShardKey) {
+			t.Errorf("Create without tag error = %v, want ErrNoShardKey", err)
+		}
+		if err := n.Save(&tagUser{ID: 1}).Error(); !errors.Is(err, ErrZeroShardKey) {
+			t.Errorf("Save with zero key error = %v, want ErrZeroShardKey", err)
+		}
+		if err := n.Delete(&tagUser{ID: 1}).Error(); !errors.Is(err, ErrZeroShardKey) {
+			t.Errorf("Delete with zero key error = %v, want ErrZeroShardKey", err)
+		}
+		if err := n.Model(&tagUser{}).Update("name", "a").Error(); !errors.Is(err, ErrZeroShardKey) {
+			t.Errorf("Model with zero key error = %v, want ErrZeroShardKey", err)
+		}
+	}
+
+	hashed, _ := newFakeNode(t, t.Name()+"/hash", WithTableNum(4), WithTableSelector(NewHashSharding()))
+	if err := hashed.Create(&tagFloat{Score: 1.5}).Error(); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Fatalf("Create with float key error = %v, want ErrUnsupportedKeyType", err)
+	}
+
+	// 一个库一张表时不需要 shard_key
+	single, ss := newFakeNode(t, t.Name()+"/single")
+	if err := single.Create(&tagMissing{ID: 1}).Error(); err != nil {
+		t.Fatalf("Create without tag on single table: %v", err)
+	}
+	if err := single.Model(&tagUser{}).Where("id = ?", 1).Update("name", "a").Error(); err != nil {
+		t.Fatalf("Model with zero key on single table: %v", err)
+	}
+	if stmts := ss.statements("UPDATE"); len(stmts) != 1 || !strings.Contains(stmts[0], "`tag_user`") {
+		t.Fatalf("statements = %q, want update of tag_user", stmts)
+	}
+
+	// 查询的结果没有 shard_key，不分表时直接查询
+	var users []tagUser
+	if err := single.Find(&users).Error(); err != nil {
+		t.Fatal(err)
+	}
+}
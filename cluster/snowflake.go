@@ -0,0 +1,283 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClockBackwards = errors.New("clock moved backwards")
+	ErrLeaseExpired   = errors.New("worker lease expired")
+)
+
+type SnowflakeOptions struct {
+	epoch        time.Time
+	workerBits   uint
+	shardBits    uint
+	sequenceBits uint
+	maxBackward  time.Duration
+	hash         HashFunc
+}
+
+type SnowflakeOption func(*SnowflakeOptions)
+
+func WithEpoch(epoch time.Time) SnowflakeOption {
+	return func(o *SnowflakeOptions) {
+		o.epoch = epoch
+	}
+}
+
+func WithWorkerBits(bits uint) SnowflakeOption {
+	return func(o *SnowflakeOptions) {
+		o.workerBits = bits
+	}
+}
+
+// WithShardBits 嵌入 sharding 信息的位数，2^bits 最好是库数乘表数的倍数
+func WithShardBits(bits uint) SnowflakeOption {
+	return func(o *SnowflakeOptions) {
+		o.shardBits = bits
+	}
+}
+
+func WithSequenceBits(bits uint) SnowflakeOption {
+	return func(o *SnowflakeOptions) {
+		o.sequenceBits = bits
+	}
+}
+
+// WithMaxBackward 时钟回拨不超过该时间时等待追上，超过时返回 ErrClockBackwards
+func WithMaxBackward(d time.Duration) SnowflakeOption {
+	return func(o *SnowflakeOptions) {
+		o.maxBackward = d
+	}
+}
+
+// WithGeneHash 计算 sharding key gene 的 hash
+func WithGeneHash(fn HashFunc) SnowflakeOption {
+	return func(o *SnowflakeOptions) {
+		o.hash = fn
+	}
+}
+
+// Snowflake 64 位按时间递增的 ID：
+//
+//	0 | timestamp(ms) | worker | shard | sequence
+//
+// shard 位保存 sharding key 的 gene 或者直接保存库表编号，SnowflakeSharding 只根据 ID 就能路由
+type Snowflake struct {
+	opt      SnowflakeOptions
+	worker   int64
+	timeBits uint
+
+	mtx      sync.Mutex
+	last     int64
+	sequence int64
+
+	// lease 不为 nil 时 worker id 是租用的，expire 之后停止生成
+	lease  WorkerLease
+	expire time.Time
+}
+
+func NewSnowflake(worker int64, opts ...SnowflakeOption) (*Snowflake, error) {
+	opt := SnowflakeOptions{
+		epoch:        time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		workerBits:   8,
+		shardBits:    6,
+		sequenceBits: 8,
+		maxBackward:  10 * time.Millisecond,
+		hash:         CRC32,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	used := opt.workerBits + opt.shardBits + opt.sequenceBits
+	if used > 63-31 {
+		return nil, fmt.Errorf("snowflake needs at least 31 timestamp bits, worker+shard+sequence is %v", used)
+	}
+
+	if worker < 0 || worker >= 1<<opt.workerBits {
+		return nil, fmt.Errorf("snowflake worker %v out of range [0, %v)", worker, 1<<opt.workerBits)
+	}
+
+	return &Snowflake{opt: opt, worker: worker, timeBits: 63 - used}, nil
+}
+
+// Gene 返回 sharding key 在 shard 位上的值
+func (s *Snowflake) Gene(key interface{}) (uint64, error) {
+	b, err := shardingKey(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.opt.hash(b) & (1<<s.opt.shardBits - 1), nil
+}
+
+// NextID 生成嵌入 key 的 gene 的 ID，与 key 路由到同一个库同一张表
+func (s *Snowflake) NextID(key interface{}) (int64, error) {
+	gene, err := s.Gene(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.Next(gene)
+}
+
+// NextIn 生成路由到 db 库 table 表的 ID，table 为库内的编号
+func (s *Snowflake) NextIn(db, table, dbNum int) (int64, error) {
+	return s.Next(uint64(table*dbNum + db))
+}
+
+// Next 生成 shard 位为 shard 的 ID
+func (s *Snowflake) Next(shard uint64) (int64, error) {
+	if shard >= 1<<s.opt.shardBits {
+		return 0, fmt.Errorf("%w: shard %v needs more than %v bits", ErrShardOutOfRange, shard, s.opt.shardBits)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// 租约过期后 worker id 可能已经被其他实例占用
+	if s.lease != nil && !time.Now().Before(s.expire) {
+		return 0, fmt.Errorf("%w: worker %v at %v", ErrLeaseExpired, s.worker, s.expire)
+	}
+
+	now := s.now()
+	if now < s.last {
+		backward := time.Duration(s.last-now) * time.Millisecond
+		if backward > s.opt.maxBackward {
+			return 0, fmt.Errorf("%w: %v", ErrClockBackwards, backward)
+		}
+
+		time.Sleep(backward)
+		if now = s.now(); now < s.last {
+			return 0, fmt.Errorf("%w: %v", ErrClockBackwards, time.Duration(s.last-now)*time.Millisecond)
+		}
+	}
+
+	if now == s.last {
+		s.sequence = (s.sequence + 1) & (1<<s.opt.sequenceBits - 1)
+		// 当前毫秒的序号用完，等到下一毫秒
+		for s.sequence == 0 && now <= s.last {
+			time.Sleep(100 * time.Microsecond)
+			now = s.now()
+		}
+	} else {
+		s.sequence = 0
+	}
+
+	if now >= 1<<s.timeBits {
+		return 0, fmt.Errorf("snowflake timestamp overflows %v bits", s.timeBits)
+	}
+
+	s.last = now
+	id := now<<(s.opt.workerBits+s.opt.shardBits+s.opt.sequenceBits) |
+		s.worker<<(s.opt.shardBits+s.opt.sequenceBits) |
+		int64(shard)<<s.opt.sequenceBits |
+		s.sequence
+	return id, nil
+}
+
+func (s *Snowflake) now() int64 {
+	return time.Since(s.opt.epoch).Milliseconds()
+}
+
+// Shard 返回 ID 的 shard 位
+func (s *Snowflake) Shard(id int64) uint64 {
+	return uint64(id>>s.opt.sequenceBits) & (1<<s.opt.shardBits - 1)
+}
+
+// Time 返回 ID 生成的时间
+func (s *Snowflake) Time(id int64) time.Time {
+	ms := id >> (s.opt.workerBits + s.opt.shardBits + s.opt.sequenceBits)
+	return s.opt.epoch.Add(time.Duration(ms) * time.Millisecond)
+}
+
+// SnowflakeID 让 SnowflakeSharding 按 ID 的 shard 位路由，如 cluster.DB(cluster.SnowflakeID(id))。
+// model 的 shard_key 字段可以直接声明为 SnowflakeID
+type SnowflakeID int64
+
+// SnowflakeSharding 根据 ID 的 shard 位路由的 DBSelector 和 TableSelector，
+// shard 在库 shard%dbNum 的第 (shard/dbNum)%tableNum 张表。
+// 只有 SnowflakeID 按 ID 解析，其他值包括普通的整数都按 sharding key 计算 gene，
+// 所以 NextID(key) 生成的 ID 和 key 路由到同一张表
+type SnowflakeSharding struct {
+	snowflake *Snowflake
+	dbNum     int
+}
+
+func NewSnowflakeSharding(s *Snowflake, dbNum int) *SnowflakeSharding {
+	if dbNum <= 0 {
+		dbNum = 1
+	}
+	return &SnowflakeSharding{snowflake: s, dbNum: dbNum}
+}
+
+func (r *SnowflakeSharding) shard(values []interface{}) (uint64, error) {
+	if len(values) != 1 {
+		return 0, fmt.Errorf("%w: snowflake sharding values len must be 1", ErrNoShardKey)
+	}
+
+	switch id := values[0].(type) {
+	case SnowflakeID:
+		return r.snowflake.Shard(int64(id)), nil
+	case *SnowflakeID:
+		if id != nil {
+			return r.snowflake.Shard(int64(*id)), nil
+		}
+	}
+	return r.snowflake.Gene(values[0])
+}
+
+func (r *SnowflakeSharding) RouteDB(num int, values ...interface{}) (uint64, error) {
+	shard, err := r.shard(values)
+	if err != nil {
+		return 0, err
+	}
+	return shard % uint64(num), nil
+}
+
+func (r *SnowflakeSharding) RouteTable(originName string, num uint64, index int, values ...interface{}) (string, error) {
+	if num == 1 {
+		return originName, nil
+	}
+
+	shard, err := r.shard(values)
+	if err != nil {
+		return "", err
+	}
+
+	if db := int(shard % uint64(r.dbNum)); db != index {
+		return "", fmt.Errorf("%w: value %v belongs to db %v not %v", ErrShardOutOfRange, values[0], db, index)
+	}
+	return fmt.Sprintf("%v_%08d", originName, uint64(index)*num+(shard/uint64(r.dbNum))%num), nil
+}
+
+// Tables 实现 TableLister
+func (r *SnowflakeSharding) Tables(originName string, num uint64, index int) ([]string, error) {
+	return defaultTables(originName, num, index), nil
+}
+
+// Number 实现 DBSelector，Cluster 会优先使用 RouteDB，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteDB
+func (r *SnowflakeSharding) Number(num int, values ...interface{}) uint64 {
+	idx, err := r.RouteDB(num, values...)
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+// Table 实现 TableSelector，ClusterNode 会优先使用 RouteTable，路由失败时 panic
+//
+// Deprecated: 直接调用时使用 RouteTable
+func (r *SnowflakeSharding) Table(originName string, num uint64, index int, values ...interface{}) string {
+	name, err := r.RouteTable(originName, num, index, values...)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnowflakeLayout(t *testing.T) {
+	s, err := NewSnowflake(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Truncate(time.Millisecond)
+	var last int64
+	for i := 0; i < 1000; i++ {
+		id, err := s.Next(33)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id <= last {
+			t.Fatalf("id %v after %v", id, last)
+		}
+		last = id
+
+		if s.Shard(id) != 33 {
+			t.Fatalf("shard of %v is %v", id, s.Shard(id))
+		}
+	}
+
+	if at := s.Time(last); at.Before(before) || at.After(time.Now()) {
+		t.Fatalf("time %v", at)
+	}
+
+	if _, err := s.Next(64); !errors.Is(err, ErrShardOutOfRange) {
+		t.Fatalf("shard 64 got %v", err)
+	}
+
+	if _, err := NewSnowflake(256); err == nil {
+		t.Fatal("worker 256 accepted with 8 bits")
+	}
+
+	if _, err := NewSnowflake(0, WithWorkerBits(16), WithShardBits(10), WithSequenceBits(7)); err == nil {
+		t.Fatal("less than 31 timestamp bits accepted")
+	}
+
+	// 正好 31 位时间戳
+	if s, err := NewSnowflake(0, WithWorkerBits(16), WithShardBits(10), WithSequenceBits(6)); err != nil || s.timeBits != 31 {
+		t.Fatalf("31 timestamp bits got %v", err)
+	}
+}
+
+func TestSnowflakeSharding(t *testing.T) {
+	s, err := NewSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewSnowflakeSharding(s, 4)
+
+	for _, key := range []interface{}{int64(7), 12345, "user-1", uint32(99)} {
+		id, err := s.NextID(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		byKey, err := r.RouteDB(4, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		byID, err := r.RouteDB(4, SnowflakeID(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if byKey != byID {
+			t.Fatalf("key %v routes to db %v, its id to %v", key, byKey, byID)
+		}
+
+		tableByKey, err := r.RouteTable("order", 8, int(byKey), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sid := SnowflakeID(id)
+		tableByID, err := r.RouteTable("order", 8, int(byKey), &sid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tableByKey != tableByID {
+			t.Fatalf("key %v routes to %v, its id to %v", key, tableByKey, tableByID)
+		}
+
+		if _, err := r.RouteTable("order", 8, int(byKey+1)%4, key); !errors.Is(err, ErrShardOutOfRange) {
+			t.Fatalf("other db got %v", err)
+		}
+	}
+
+	if _, err := r.RouteDB(4); !errors.Is(err, ErrNoShardKey) {
+		t.Fatalf("no value got %v", err)
+	}
+
+	// 普通整数按 gene 路由，不会被当成 ID 解析
+	raw := int64(1<<20 | 3<<8)
+	gene, _ := s.Gene(raw)
+	if got, _ := r.RouteDB(4, raw); got != gene%4 {
+		t.Fatalf("raw key routes to %v, gene %v", got, gene)
+	}
+}
+
+type fakeLease struct {
+	renew atomic.Value
+}
+
+func (l *fakeLease) Allocate() (int64, error) {
+	return 3, nil
+}
+
+func (l *fakeLease) Renew(worker int64) error {
+	if err, _ := l.renew.Load().(error); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l *fakeLease) TTL() time.Duration {
+	return 100 * time.Millisecond
+}
+
+func TestSnowflakeLease(t *testing.T) {
+	lease := &fakeLease{}
+	s, err := NewSnowflakeWithAllocator(lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Next(0); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := s.Renew(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := s.Next(0); err != nil {
+		t.Fatalf("renewed lease: %v", err)
+	}
+
+	lease.renew.Store(errors.New("worker id 3 is taken by other host"))
+	if err := s.Renew(); err == nil {
+		t.Fatal("renew failure not reported")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := s.Next(0); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("expired lease got %v", err)
+	}
+
+	plain, err := NewSnowflakeWithAllocator(WorkerAllocatorFunc(func() (int64, error) { return 1, nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Renew(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableWorkerAllocator(t *testing.T) {
+	closed, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name() + "/closed"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := NewSharding(WithMaster(closed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewTableWorkerAllocator(sh, "snowflake_workers", "host-a", 4, time.Minute)
+	if _, err := a.Allocate(); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("Allocate error = %v, want ErrNotOpen", err)
+	}
+	if err := a.Renew(0); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("Renew error = %v, want ErrNotOpen", err)
+	}
+
+	// heartbeat 使用数据库的时间
+	sh, master, _ := newFakeSharding(t, t.Name(), 0)
+	a = NewTableWorkerAllocator(sh, "snowflake_workers", "host-a", 4, time.Minute)
+	if _, err := a.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Renew(0); err != nil {
+		t.Fatal(err)
+	}
+
+	stmts := master.statements("snowflake_workers")
+	if len(stmts) != 2 {
+		t.Fatalf("statements %v", stmts)
+	}
+	for _, stmt := range stmts {
+		if !strings.Contains(stmt, "heartbeat = NOW(6)") && !strings.Contains(stmt, "?, NOW(6))") {
+			t.Fatalf("heartbeat not set by database clock: %v", stmt)
+		}
+	}
+}
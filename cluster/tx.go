@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+)
+
+// ShardTx 一个库上的事务，所有读写都在 master 的事务连接上执行，并保留分表的路由信息
+type ShardTx struct {
+	*ClusterNode
+
+	savepoints *int
+}
+
+// Transaction 在 master 上开启事务执行 fn，fn 返回错误或者 panic 时回滚，否则提交。
+// ctx 不能取消时使用 WithContext 的 ctx
+func (n *Sharding) Transaction(ctx context.Context, fn func(tx *ShardTx) error) (err error) {
+	if n.err != nil {
+		return n.err
+	}
+
+	master := n.Master()
+	if master.db == nil {
+		return ErrNotOpen
+	}
+
+	if ctx == nil || ctx.Done() == nil {
+		ctx = n.context()
+	}
+
+	db := master.db.BeginTx(ctx, nil)
+	if db.Error != nil {
+		return db.Error
+	}
+
+	tx := &ShardTx{
+		ClusterNode: &ClusterNode{db: db, opts: n.nodeOpts(master), state: master.state, ShardingValues: n.ShardingValues},
+		savepoints:  new(int),
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			db.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		db.Rollback()
+		return err
+	}
+
+	if err = db.Commit().Error; err == nil && n.session != nil {
+		n.session.wrote(n)
+	}
+	return err
+}
+
+// Transaction 嵌套事务，使用 savepoint 实现，fn 失败时只回滚到 savepoint
+func (tx *ShardTx) Transaction(fn func(tx *ShardTx) error) (err error) {
+	*tx.savepoints++
+	sp := fmt.Sprintf("sp_%d", *tx.savepoints)
+	if err := tx.db.Exec("SAVEPOINT " + sp).Error; err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.db.Exec("ROLLBACK TO SAVEPOINT " + sp)
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		tx.db.Exec("ROLLBACK TO SAVEPOINT " + sp)
+		return err
+	}
+	return tx.db.Exec("RELEASE SAVEPOINT " + sp).Error
+}
+
+// Table 在同一个事务中切换到 values 路由的表
+func (tx *ShardTx) Table(values ...interface{}) *ShardTx {
+	return &ShardTx{ClusterNode: tx.ClusterNode.Table(values...), savepoints: tx.savepoints}
+}
@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestShardTxSavepoint(t *testing.T) {
+	sh, ms, _ := newFakeSharding(t, t.Name(), 0)
+
+	cause := errors.New("inner failed")
+	err := sh.Transaction(context.Background(), func(tx *ShardTx) error {
+		if err := tx.Transaction(func(tx *ShardTx) error {
+			return tx.Exec("UPDATE t SET a = 1").Error()
+		}); err != nil {
+			return err
+		}
+
+		// 嵌套事务失败只回滚到 savepoint，外层事务继续提交
+		if err := tx.Transaction(func(tx *ShardTx) error {
+			tx.Exec("UPDATE t SET a = 2")
+			return cause
+		}); !errors.Is(err, cause) {
+			t.Errorf("inner error = %v, want %v", err, cause)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT sp_1", "UPDATE t SET a = 1", "RELEASE SAVEPOINT sp_1",
+		"SAVEPOINT sp_2", "UPDATE t SET a = 2", "ROLLBACK TO SAVEPOINT sp_2",
+		"COMMIT",
+	}
+	if got := ms.statements(""); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("statements = %q, want %q", got, want)
+	}
+}
+
+func TestTransactionContext(t *testing.T) {
+	sh, ms, _ := newFakeSharding(t, t.Name(), 0)
+
+	// 没有传入可以取消的 ctx 时使用 WithContext 的 ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := sh.WithContext(ctx).Transaction(context.Background(), func(tx *ShardTx) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Transaction error = %v, want context.Canceled", err)
+	}
+	if stmts := ms.statements(""); len(stmts) != 0 {
+		t.Fatalf("statements = %q, want none", stmts)
+	}
+}
+
+func TestTransactionNotOpen(t *testing.T) {
+	node, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := NewSharding(WithMaster(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sh.Transaction(context.Background(), func(tx *ShardTx) error { return nil })
+	if !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("Transaction error = %v, want ErrNotOpen", err)
+	}
+}
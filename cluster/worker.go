@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNoWorker = errors.New("no free worker id")
+
+// WorkerAllocator 分配 Snowflake 的 worker id
+type WorkerAllocator interface {
+	Allocate() (int64, error)
+}
+
+// WorkerLease 按租约分配 worker id 的 WorkerAllocator，Snowflake 在租约过期后停止生成 ID
+type WorkerLease interface {
+	WorkerAllocator
+	Renew(worker int64) error
+	TTL() time.Duration
+}
+
+type WorkerAllocatorFunc func() (int64, error)
+
+func (f WorkerAllocatorFunc) Allocate() (int64, error) {
+	return f()
+}
+
+// TableWorkerAllocator 在表中租用 worker id，租约超过 ttl 没有续期的 id 可以被其他实例占用。
+// heartbeat 的写入和过期判断都使用数据库的 NOW()，不受各实例之间时钟偏差的影响。
+// 表结构：
+//
+//	CREATE TABLE snowflake_workers (
+//		worker_id INT NOT NULL PRIMARY KEY,
+//		host VARCHAR(128) NOT NULL,
+//		heartbeat DATETIME(6) NOT NULL
+//	)
+type TableWorkerAllocator struct {
+	sharding  *Sharding
+	table     string
+	host      string
+	maxWorker int64
+	ttl       time.Duration
+}
+
+// NewTableWorkerAllocator host 标识当前实例，同一个 host 重启后优先拿回原来的 id
+func NewTableWorkerAllocator(sharding *Sharding, table, host string, maxWorker int64, ttl time.Duration) *TableWorkerAllocator {
+	return &TableWorkerAllocator{
+		sharding:  sharding,
+		table:     table,
+		host:      host,
+		maxWorker: maxWorker,
+		ttl:       ttl,
+	}
+}
+
+func (a *TableWorkerAllocator) Allocate() (int64, error) {
+	db := a.sharding.Master().db
+	if db == nil {
+		return 0, ErrNotOpen
+	}
+
+	for id := int64(0); id < a.maxWorker; id++ {
+		res := db.Exec(fmt.Sprintf("INSERT IGNORE INTO %s (worker_id, host, heartbeat) VALUES (?, ?, NOW(6))", a.table),
+			id, a.host)
+		if res.Error != nil {
+			return 0, res.Error
+		}
+
+		if res.RowsAffected == 1 {
+			return id, nil
+		}
+
+		res = db.Exec(fmt.Sprintf("UPDATE %s SET host = ?, heartbeat = NOW(6) WHERE worker_id = ? AND "+
+			"(host = ? OR heartbeat < NOW(6) - INTERVAL ? MICROSECOND)", a.table),
+			a.host, id, a.host, a.ttl.Microseconds())
+		if res.Error != nil {
+			return 0, res.Error
+		}
+
+		if res.RowsAffected == 1 {
+			return id, nil
+		}
+	}
+	return 0, ErrNoWorker
+}
+
+// Renew 续期 worker id 的租约，通过 Snowflake.Renew 调用才会延长 Snowflake 的租约
+func (a *TableWorkerAllocator) Renew(worker int64) error {
+	db := a.sharding.Master().db
+	if db == nil {
+		return ErrNotOpen
+	}
+
+	res := db.Exec(fmt.Sprintf("UPDATE %s SET heartbeat = NOW(6) WHERE worker_id = ? AND host = ?", a.table),
+		worker, a.host)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected != 1 {
+		return fmt.Errorf("worker id %v is taken by other host", worker)
+	}
+	return nil
+}
+
+func (a *TableWorkerAllocator) TTL() time.Duration {
+	return a.ttl
+}
+
+// NewSnowflakeWithAllocator 用 allocator 分配的 worker id 创建 Snowflake，allocator 是 WorkerLease 时
+// 需要以小于 ttl 的间隔调用 Snowflake.Renew
+func NewSnowflakeWithAllocator(allocator WorkerAllocator, opts ...SnowflakeOption) (*Snowflake, error) {
+	start := time.Now()
+	worker, err := allocator.Allocate()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewSnowflake(worker, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if lease, ok := allocator.(WorkerLease); ok {
+		s.lease, s.expire = lease, start.Add(lease.TTL())
+	}
+	return s, nil
+}
+
+// Renew 续期 worker id 的租约，失败时租约不变，过期后 Next 返回 ErrLeaseExpired。
+// 租约从发起续期的时间开始计算，比表中的 heartbeat 早，不会在其他实例接手之后还在生成
+func (s *Snowflake) Renew() error {
+	if s.lease == nil {
+		return nil
+	}
+
+	start := time.Now()
+	if err := s.lease.Renew(s.worker); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	if expire := start.Add(s.lease.TTL()); expire.After(s.expire) {
+		s.expire = expire
+	}
+	s.mtx.Unlock()
+	return nil
+}
@@ -0,0 +1,392 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-gorm/gorm"
+)
+
+var ErrXAInDoubt = errors.New("xa transaction in doubt")
+
+type XAOptions struct {
+	log     TxLog
+	prefix  string
+	ctx     context.Context
+	timeout time.Duration
+}
+
+type XAOption func(*XAOptions)
+
+// WithTxLog 不设置时两个以上分支的事务会返回错误
+func WithTxLog(log TxLog) XAOption {
+	return func(o *XAOptions) {
+		o.log = log
+	}
+}
+
+// WithXIDPrefix 必须设置，每个实例唯一且重启后不变，如主机名。TxLog 只记录本实例的事务，
+// RecoverXA 只处理本实例前缀的分支，不会回滚其他实例已经决定提交的分支。
+// 只能包含字母、数字和 "-._"，最长 39 个字符
+func WithXIDPrefix(prefix string) XAOption {
+	return func(o *XAOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithXAContext 默认使用 Cluster.WithContext 的 ctx，只用于 XA PREPARE 之前的语句，
+// 提交和回滚不受它取消的影响
+func WithXAContext(ctx context.Context) XAOption {
+	return func(o *XAOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithXATimeout 提交和回滚失败时重试的最长时间，默认 30s，超时后由 RecoverXA 处理
+func WithXATimeout(d time.Duration) XAOption {
+	return func(o *XAOptions) {
+		o.timeout = d
+	}
+}
+
+func newXAOptions(ctx context.Context, opts []XAOption) (XAOptions, error) {
+	opt := XAOptions{
+		ctx:     ctx,
+		timeout: 30 * time.Second,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	// gtrid 最长 64 字节，前缀之后是 "_" 和 24 位随机数
+	if opt.prefix == "" || len(opt.prefix) > 39 || strings.Trim(opt.prefix, xidChars) != "" {
+		return opt, fmt.Errorf("%w: xa needs a unique xid prefix, got %q", ErrInvalidConfig, opt.prefix)
+	}
+	return opt, nil
+}
+
+const xidChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-._"
+
+// ownXID gtrid 是否由 prefix 的实例生成，前缀为 "a" 的实例不会处理 "a_b" 的事务
+func ownXID(gtrid, prefix string) bool {
+	rest := strings.TrimPrefix(gtrid, prefix+"_")
+	if rest == gtrid || len(rest) != 24 {
+		return false
+	}
+
+	_, err := hex.DecodeString(rest)
+	return err == nil
+}
+
+type xaBranch struct {
+	index int
+	db    *gorm.DB
+	conn  *sql.Conn
+	ended bool
+	// prepared 之后的分支在连接断开后仍然存在，提交和回滚都要重试
+	prepared bool
+}
+
+// XATx 跨库的 XA 事务，每个库的 master 上一个分支，分支在第一次访问该库时开启
+type XATx struct {
+	cluster  *Cluster
+	opt      XAOptions
+	gtrid    string
+	branches []*xaBranch
+}
+
+// DB 返回 values 路由到的库上的分支，语句都在该分支的连接上执行
+func (tx *XATx) DB(values ...interface{}) (*ClusterNode, error) {
+	idx, err := tx.cluster.dbIndex(values...)
+	if err != nil {
+		return nil, err
+	}
+
+	sh := tx.cluster.shardingList[idx]
+	master := sh.Master()
+	for _, b := range tx.branches {
+		if b.index == int(idx) {
+			return &ClusterNode{db: b.db, opts: sh.nodeOpts(master), ShardingValues: values}, nil
+		}
+	}
+
+	db, conn, err := master.openConn(tx.opt.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &xaBranch{index: int(idx), db: db, conn: conn}
+	if err := b.exec("XA START %s", tx.xid(b)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tx.branches = append(tx.branches, b)
+	return &ClusterNode{db: db, opts: sh.nodeOpts(master), ShardingValues: values}, nil
+}
+
+func (tx *XATx) xid(b *xaBranch) string {
+	return fmt.Sprintf("'%s','%d'", tx.gtrid, b.index)
+}
+
+func (b *xaBranch) exec(format string, xid string) error {
+	return b.db.Exec(fmt.Sprintf(format, xid)).Error
+}
+
+// finish 执行 XA COMMIT/ROLLBACK，已经决定的结果不能因为请求取消而放弃，
+// 不使用请求的 ctx，失败时重试到 XAOptions.timeout
+func (tx *XATx) finish(b *xaBranch, format string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tx.opt.timeout)
+	defer cancel()
+
+	stmt := fmt.Sprintf(format, tx.xid(b))
+	wait := 10 * time.Millisecond
+	for i := 0; ; i++ {
+		_, err := b.conn.ExecContext(ctx, stmt)
+		if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+			// 连接断开后 prepared 的分支可以在其他连接上提交，切换后的 master 可能还没有 Open
+			if db := tx.cluster.shardingList[b.index].Master().db; db != nil {
+				_, err = db.DB().ExecContext(ctx, stmt)
+			} else {
+				err = ErrNotOpen
+			}
+		}
+
+		// 重试时 XAER_NOTA 说明上一次已经执行成功，只是没有收到结果
+		if err == nil || (i > 0 && strings.Contains(err.Error(), "XAER_NOTA")) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		if wait < time.Second {
+			wait *= 2
+		}
+	}
+}
+
+func (b *xaBranch) end(tx *XATx) error {
+	if b.ended {
+		return nil
+	}
+	b.ended = true
+	return b.exec("XA END %s", tx.xid(b))
+}
+
+// rollback 回滚所有分支，重试超时的 prepared 分支由 RecoverXA 回滚
+func (tx *XATx) rollback() {
+	for _, b := range tx.branches {
+		if b.prepared {
+			tx.finish(b, "XA ROLLBACK %s")
+			continue
+		}
+
+		// 没有 prepare 的分支在连接断开时由 MySQL 回滚，不需要重试
+		ctx, cancel := context.WithTimeout(context.Background(), tx.opt.timeout)
+		if !b.ended {
+			b.ended = true
+			b.conn.ExecContext(ctx, fmt.Sprintf("XA END %s", tx.xid(b)))
+		}
+		b.conn.ExecContext(ctx, fmt.Sprintf("XA ROLLBACK %s", tx.xid(b)))
+		cancel()
+	}
+}
+
+func (tx *XATx) close() {
+	for _, b := range tx.branches {
+		b.conn.Close()
+	}
+}
+
+// commit 两阶段提交，所有分支 prepare 成功后先写日志再提交
+func (tx *XATx) commit() error {
+	switch len(tx.branches) {
+	case 0:
+		return nil
+	case 1:
+		b := tx.branches[0]
+		if err := b.end(tx); err != nil {
+			tx.rollback()
+			return err
+		}
+
+		// 没有 prepare 的分支在连接断开时会被回滚，不能在其他连接上重试
+		ctx, cancel := context.WithTimeout(context.Background(), tx.opt.timeout)
+		defer cancel()
+		if _, err := b.conn.ExecContext(ctx, fmt.Sprintf("XA COMMIT %s ONE PHASE", tx.xid(b))); err != nil {
+			tx.rollback()
+			return err
+		}
+		return nil
+	}
+
+	if tx.opt.log == nil {
+		tx.rollback()
+		return errors.New("xa transaction on multiple db needs TxLog")
+	}
+
+	rec := &XARecord{XID: tx.gtrid}
+	for _, b := range tx.branches {
+		if err := b.end(tx); err != nil {
+			tx.rollback()
+			return err
+		}
+
+		if err := b.exec("XA PREPARE %s", tx.xid(b)); err != nil {
+			tx.rollback()
+			return err
+		}
+		b.prepared = true
+		rec.Branches = append(rec.Branches, b.index)
+	}
+
+	if err := tx.opt.log.Save(rec); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	// 已经决定提交，重试超时的分支只能由 RecoverXA 提交，日志中只保留这些分支
+	var (
+		failed   []string
+		branches []int
+	)
+	for _, b := range tx.branches {
+		if err := tx.finish(b, "XA COMMIT %s"); err != nil {
+			failed = append(failed, fmt.Sprintf("db %v: %v", b.index, err))
+			branches = append(branches, b.index)
+		}
+	}
+
+	if len(failed) > 0 {
+		if len(branches) < len(rec.Branches) {
+			if err := tx.opt.log.Save(&XARecord{XID: tx.gtrid, Branches: branches}); err != nil {
+				failed = append(failed, fmt.Sprintf("log: %v", err))
+			}
+		}
+		return fmt.Errorf("%w: %v %v", ErrXAInDoubt, tx.gtrid, strings.Join(failed, "; "))
+	}
+	return tx.opt.log.Delete(tx.gtrid)
+}
+
+// XA 在 fn 中通过 tx.DB 访问的库上执行 XA 事务，fn 返回错误或者 panic 时回滚所有分支，
+// 需要 WithXIDPrefix，两个以上的库需要 WithTxLog
+func (c *Cluster) XA(fn func(tx *XATx) error, opts ...XAOption) (err error) {
+	opt, err := newXAOptions(c.context(), opts)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	tx := &XATx{cluster: c, opt: opt, gtrid: opt.prefix + "_" + hex.EncodeToString(b)}
+	defer tx.close()
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	return tx.commit()
+}
+
+// RecoverXA 处理本实例崩溃后遗留的 prepared 分支，日志中的事务提交，其余回滚。
+// 只处理 WithXIDPrefix 前缀的分支，log 和 prefix 必须和崩溃前的 XA 使用的相同，
+// 应该在本实例没有进行中的 XA 事务时调用，如进程启动时
+func (c *Cluster) RecoverXA(log TxLog, opts ...XAOption) error {
+	opt, err := newXAOptions(c.context(), opts)
+	if err != nil {
+		return err
+	}
+
+	recs, err := log.Records()
+	if err != nil {
+		return err
+	}
+
+	committed := make(map[string]bool, len(recs))
+	for _, rec := range recs {
+		committed[rec.XID] = true
+	}
+
+	for i, sh := range c.shardingList {
+		if err := recoverShard(opt.ctx, sh.Master(), opt.prefix, committed); err != nil {
+			return fmt.Errorf("recover xa on db %v: %w", i, err)
+		}
+	}
+
+	for _, rec := range recs {
+		if err := log.Delete(rec.XID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recoverShard(ctx context.Context, node *ClusterNode, prefix string, committed map[string]bool) error {
+	db, conn, err := node.openConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := db.Raw("XA RECOVER").Rows()
+	if err != nil {
+		return err
+	}
+
+	type xid struct{ gtrid, bqual string }
+	var xids []xid
+	for rows.Next() {
+		var (
+			formatID, gtridLen, bqualLen int
+			data                         string
+		)
+
+		if err := rows.Scan(&formatID, &gtridLen, &bqualLen, &data); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if gtridLen+bqualLen > len(data) || !ownXID(data[:gtridLen], prefix) {
+			continue
+		}
+		xids = append(xids, xid{gtrid: data[:gtridLen], bqual: data[gtridLen : gtridLen+bqualLen]})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, x := range xids {
+		stmt := "XA ROLLBACK '%s','%s'"
+		if committed[x.gtrid] {
+			stmt = "XA COMMIT '%s','%s'"
+		}
+
+		if err := db.Exec(fmt.Sprintf(stmt, x.gtrid, x.bqual)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// XARecord 已经决定提交的 XA 事务，Branches 为参与的库
+type XARecord struct {
+	XID      string
+	Branches []int
+}
+
+// TxLog 持久化已经决定提交的 XA 事务，崩溃后 RecoverXA 根据它提交 prepared 的分支，
+// 不在日志中的 prepared 分支会被回滚
+type TxLog interface {
+	Save(rec *XARecord) error
+	Delete(xid string) error
+	Records() ([]*XARecord, error)
+}
+
+// FileTxLog 每个事务一个文件，写入后 fsync 再 rename
+type FileTxLog struct {
+	dir string
+}
+
+func NewFileTxLog(dir string) (*FileTxLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileTxLog{dir: dir}, nil
+}
+
+func (l *FileTxLog) Save(rec *XARecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(l.dir, rec.XID+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(l.dir, rec.XID+".json")); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+func (l *FileTxLog) Delete(xid string) error {
+	err := os.Remove(filepath.Join(l.dir, xid+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *FileTxLog) Records() ([]*XARecord, error) {
+	files, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []*XARecord
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(l.dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var rec XARecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, &rec)
+	}
+	return recs, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
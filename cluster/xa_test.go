@@ -0,0 +1,265 @@
+package cluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// xaUpdate 在 0 和 1 两个库上各执行一条语句
+func xaUpdate(tx *XATx) error {
+	for _, key := range []int64{0, 1} {
+		node, err := tx.DB(key)
+		if err != nil {
+			return err
+		}
+
+		if err := node.Exec("UPDATE t SET a = 1").Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func failOn(stmt string, times int32) func(query string) error {
+	var n int32
+	return func(query string) error {
+		if strings.HasPrefix(query, stmt) && (times < 0 || atomic.AddInt32(&n, 1) <= times) {
+			return errors.New("lock wait timeout")
+		}
+		return nil
+	}
+}
+
+func TestXAPrefix(t *testing.T) {
+	c, _ := newFakeCluster(t, t.Name(), 2)
+	log := newMemoryTxLog()
+
+	for _, opts := range [][]XAOption{
+		{WithTxLog(log)},
+		{WithTxLog(log), WithXIDPrefix("a'b")},
+		{WithTxLog(log), WithXIDPrefix(strings.Repeat("a", 40))},
+	} {
+		if err := c.XA(xaUpdate, opts...); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("xa got %v", err)
+		}
+	}
+
+	if err := c.RecoverXA(log); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("recover got %v", err)
+	}
+
+	if !ownXID("node1_0123456789abcdef01234567", "node1") ||
+		ownXID("node1_x_0123456789abcdef01234567", "node1") ||
+		ownXID("node2_0123456789abcdef01234567", "node1") {
+		t.Error("ownXID")
+	}
+}
+
+func TestXAPrepareFailure(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	servers[1].setHook(failOn("XA PREPARE", -1))
+
+	log := newMemoryTxLog()
+	if err := c.XA(xaUpdate, WithTxLog(log), WithXIDPrefix("node1")); err == nil {
+		t.Fatal("prepare failure not reported")
+	}
+
+	if recs, _ := log.Records(); len(recs) != 0 {
+		t.Fatalf("log saved %v", recs)
+	}
+
+	for i, s := range servers {
+		if len(s.statements("XA COMMIT")) != 0 || len(s.statements("XA ROLLBACK")) != 1 {
+			t.Errorf("db %v: %v", i, s.statements("XA"))
+		}
+	}
+}
+
+// savingLog 保存日志时检查还没有分支提交，并取消请求的 ctx
+type savingLog struct {
+	*memoryTxLog
+	t       *testing.T
+	servers []*fakeServer
+	cancel  context.CancelFunc
+}
+
+func (l *savingLog) Save(rec *XARecord) error {
+	for i, s := range l.servers {
+		if len(s.statements("XA COMMIT")) != 0 {
+			l.t.Errorf("db %v committed before log saved", i)
+		}
+	}
+	l.cancel()
+	return l.memoryTxLog.Save(rec)
+}
+
+func TestXACommitAfterLog(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	servers[1].setHook(failOn("XA COMMIT", 2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := &savingLog{memoryTxLog: newMemoryTxLog(), t: t, servers: servers, cancel: cancel}
+	if err := c.XA(xaUpdate, WithTxLog(log), WithXIDPrefix("node1"), WithXAContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	if recs, _ := log.Records(); len(recs) != 0 {
+		t.Fatalf("log not deleted %v", recs)
+	}
+
+	if n := len(servers[0].statements("XA COMMIT")); n != 1 {
+		t.Fatalf("db 0 committed %v times", n)
+	}
+
+	// 请求的 ctx 取消后仍然重试到成功
+	if n := len(servers[1].statements("XA COMMIT")); n != 3 {
+		t.Fatalf("db 1 committed %v times", n)
+	}
+}
+
+func TestXACommitInDoubt(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	servers[1].setHook(failOn("XA COMMIT", -1))
+
+	log := newMemoryTxLog()
+	err := c.XA(xaUpdate, WithTxLog(log), WithXIDPrefix("node1"), WithXATimeout(50*time.Millisecond))
+	if !errors.Is(err, ErrXAInDoubt) {
+		t.Fatalf("got %v", err)
+	}
+
+	if recs, _ := log.Records(); len(recs) != 1 || len(servers[0].statements("XA ROLLBACK")) != 0 {
+		t.Fatalf("log %v, db 0 %v", recs, servers[0].statements("XA"))
+	}
+}
+
+func TestRecoverXA(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+
+	const (
+		committed = "node1_aaaaaaaaaaaaaaaaaaaaaaaa"
+		aborted   = "node1_bbbbbbbbbbbbbbbbbbbbbbbb"
+		other     = "node2_cccccccccccccccccccccccc"
+		nested    = "node1_x_dddddddddddddddddddddddd"
+	)
+	for _, s := range servers {
+		s.setRows(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			if strings.TrimSpace(query) != "XA RECOVER" {
+				return nil, nil
+			}
+
+			var rows [][]driver.Value
+			for _, gtrid := range []string{committed, aborted, other, nested} {
+				rows = append(rows, []driver.Value{int64(1), int64(len(gtrid)), int64(1), gtrid + "0"})
+			}
+			return []string{"formatID", "gtrid_length", "bqual_length", "data"}, rows
+		})
+	}
+
+	log := newMemoryTxLog()
+	log.Save(&XARecord{XID: committed, Branches: []int{0, 1}})
+
+	if err := c.RecoverXA(log, WithXIDPrefix("node1")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range servers {
+		got := s.statements("XA ")
+		want := []string{" XA RECOVER", "XA COMMIT '" + committed + "','0'", "XA ROLLBACK '" + aborted + "','0'"}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("db %v: %v", i, got)
+		}
+	}
+
+	if recs, _ := log.Records(); len(recs) != 0 {
+		t.Fatalf("log not cleared %v", recs)
+	}
+}
+
+// switchingLog 保存日志时把 db 1 的 master 换成没有 Open 的节点
+type switchingLog struct {
+	*memoryTxLog
+	sh   *Sharding
+	node *ClusterNode
+}
+
+func (l *switchingLog) Save(rec *XARecord) error {
+	if l.node != nil {
+		l.sh.mtx.Lock()
+		l.sh.master, l.node = l.node, nil
+		l.sh.mtx.Unlock()
+	}
+	return l.memoryTxLog.Save(rec)
+}
+
+func TestXANotOpen(t *testing.T) {
+	c, servers := newFakeCluster(t, t.Name(), 2)
+	closed, err := NewClusterNode(WithDB(&DB{Driver: "fakedb", DataSource: t.Name() + "/closed"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接断开后新的 master 没有 Open，分支留在日志中由 RecoverXA 提交
+	servers[1].setHook(func(query string) error {
+		if strings.HasPrefix(query, "XA COMMIT") {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	log := &switchingLog{memoryTxLog: newMemoryTxLog(), sh: c.shardingList[1].origin(), node: closed}
+	err = c.XA(xaUpdate, WithTxLog(log), WithXIDPrefix("node1"), WithXATimeout(50*time.Millisecond))
+	if !errors.Is(err, ErrXAInDoubt) || !strings.Contains(err.Error(), ErrNotOpen.Error()) {
+		t.Fatalf("commit got %v", err)
+	}
+	if recs, _ := log.Records(); len(recs) != 1 || len(recs[0].Branches) != 1 || recs[0].Branches[0] != 1 {
+		t.Fatalf("log %v", recs)
+	}
+
+	// 没有 Open 的库上不能开启分支
+	err = c.XA(xaUpdate, WithTxLog(newMemoryTxLog()), WithXIDPrefix("node1"))
+	if !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("start got %v", err)
+	}
+}
+
+// memoryTxLog 保存在内存中的 TxLog
+type memoryTxLog struct {
+	mtx  sync.Mutex
+	recs map[string]*XARecord
+}
+
+func newMemoryTxLog() *memoryTxLog {
+	return &memoryTxLog{recs: make(map[string]*XARecord)}
+}
+
+func (l *memoryTxLog) Save(rec *XARecord) error {
+	l.mtx.Lock()
+	l.recs[rec.XID] = rec
+	l.mtx.Unlock()
+	return nil
+}
+
+func (l *memoryTxLog) Delete(xid string) error {
+	l.mtx.Lock()
+	delete(l.recs, xid)
+	l.mtx.Unlock()
+	return nil
+}
+
+func (l *memoryTxLog) Records() ([]*XARecord, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	recs := make([]*XARecord, 0, len(l.recs))
+	for _, rec := range l.recs {
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}